@@ -0,0 +1,115 @@
+package cmds
+
+import (
+	"codeaid/messages"
+	"codeaid/utils"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// branchPreviewLength limits how much of each prompt is shown in listings
+const branchPreviewLength = 50
+
+// BranchesCommand lists and switches conversation branches
+type BranchesCommand struct{}
+
+// Name returns the command name
+func (c BranchesCommand) Name() string {
+	return "/branches"
+}
+
+// Description returns the command description
+func (c BranchesCommand) Description() string {
+	return "List or switch conversation branches"
+}
+
+// Args describes the command arguments
+func (c BranchesCommand) Args() ArgSpec {
+	return ArgSpec{
+		Usage:    "/branches [message branch]",
+		Examples: []string{"/branches", "/branches 2 1"},
+	}
+}
+
+// Complete suggests the messages that have alternatives, then the
+// branches of the chosen message
+func (c BranchesCommand) Complete(args string) []Completion {
+	message, branch, chosen := strings.Cut(args, " ")
+	if strings.Contains(branch, " ") {
+		return nil
+	}
+
+	completions := []Completion{}
+	for _, b := range utils.Branches() {
+		index := strconv.Itoa(b.Index)
+		if !chosen {
+			completions = append(completions, Completion{Value: index, Description: preview(b.Prompt)})
+			continue
+		}
+		if index != message {
+			continue
+		}
+		for i, variant := range b.Variants {
+			completions = append(completions, Completion{Value: index + " " + strconv.Itoa(i+1), Description: preview(variant)})
+		}
+	}
+	return filterNumbered(completions, args)
+}
+
+// Execute executes the command
+func (c BranchesCommand) Execute(args string) tea.Cmd {
+	return func() tea.Msg {
+		fields := strings.Fields(args)
+		if len(fields) == 0 {
+			return listBranches()
+		}
+
+		if len(fields) != 2 {
+			return messages.CommandResponseMsg("Usage: /branches [message branch]")
+		}
+		index, err1 := strconv.Atoi(fields[0])
+		alternative, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			return messages.CommandResponseMsg("Usage: /branches [message branch]")
+		}
+
+		if err := utils.SelectBranch(index, alternative); err != nil {
+			return messages.CommandResponseMsg(fmt.Sprintf("Error: %v", err))
+		}
+		return messages.HistoryChangedMsg{}
+	}
+}
+
+// listBranches shows every message on the active branch with alternatives
+func listBranches() tea.Msg {
+	branches := utils.Branches()
+	if len(branches) == 0 {
+		return messages.CommandResponseMsg("No branches yet. Use /retry or /edit to create one.")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Branches (alt+left/alt+right switches the latest):\n")
+	for _, branch := range branches {
+		sb.WriteString(fmt.Sprintf("Message %d (%d/%d):\n", branch.Index, branch.Current, branch.Count))
+		for i, variant := range branch.Variants {
+			marker := "  "
+			if i+1 == branch.Current {
+				marker = "* "
+			}
+			sb.WriteString(fmt.Sprintf("  %s%d) %s\n", marker, i+1, preview(variant)))
+		}
+	}
+	return messages.CommandResponseMsg(sb.String())
+}
+
+// preview shortens text to a single line for listings
+func preview(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > branchPreviewLength {
+		return string(runes[:branchPreviewLength]) + "..."
+	}
+	return text
+}
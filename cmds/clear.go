@@ -18,6 +18,11 @@ func (c ClearCommand) Description() string {
 	return "Clear conversation history"
 }
 
+// Args describes the command arguments
+func (c ClearCommand) Args() ArgSpec {
+	return ArgSpec{Usage: "/clear"}
+}
+
 // Execute executes the command
 func (c ClearCommand) Execute(args string) tea.Cmd {
 	return func() tea.Msg {
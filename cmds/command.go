@@ -15,3 +15,58 @@ type Command interface {
 	// Execute executes the command and returns a tea.Cmd for Bubble Tea to process
 	Execute(args string) tea.Cmd
 }
+
+// Completion is a single suggestion shown in the hints list
+type Completion struct {
+	// Value is the text that replaces the completed part of the input
+	Value string
+
+	// Description briefly explains the suggestion
+	Description string
+}
+
+// ArgKind describes what kind of free-form argument a command accepts
+type ArgKind int
+
+// Argument kinds used for default completion
+const (
+	ArgNone ArgKind = iota
+	ArgText
+	ArgFile
+	ArgModel
+	ArgCommand
+)
+
+// ArgSpec describes a command's arguments for completion and help
+type ArgSpec struct {
+	// Usage is a one-line synopsis such as "/help [command]"
+	Usage string
+
+	// Examples are complete example invocations
+	Examples []string
+
+	// Subcommands are offered as completions for the first argument
+	Subcommands []Completion
+
+	// Kind selects the default completion for the remaining arguments
+	Kind ArgKind
+}
+
+// ArgSpecProvider is implemented by commands that describe their arguments
+type ArgSpecProvider interface {
+	Args() ArgSpec
+}
+
+// Completer is implemented by commands that complete their own arguments.
+// Complete receives everything after the command name and returns
+// suggestions whose Value replaces that text.
+type Completer interface {
+	Complete(args string) []Completion
+}
+
+// TerminalCommand is implemented by commands that only work in the
+// terminal interface, such as ones that suspend it to run another
+// program. Modes without a terminal refuse them.
+type TerminalCommand interface {
+	NeedsTerminal() bool
+}
@@ -0,0 +1,83 @@
+package cmds
+
+import (
+	"codeaid/messages"
+	"codeaid/utils"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Number of models a comparison accepts
+const (
+	minCompareModels = 2
+	maxCompareModels = 3
+)
+
+// comparePick is the subcommand that continues with a compared answer
+const comparePick = "pick"
+
+// CompareCommand answers the last message with several models side by
+// side; /compare pick N continues with one of the answers
+type CompareCommand struct{}
+
+// Name returns the command name
+func (c CompareCommand) Name() string {
+	return "/compare"
+}
+
+// Description returns the command description
+func (c CompareCommand) Description() string {
+	return "Compare answers from several models"
+}
+
+// Args describes the command arguments
+func (c CompareCommand) Args() ArgSpec {
+	return ArgSpec{
+		Usage:    "/compare modelA modelB [modelC] | /compare pick N",
+		Examples: []string{"/compare anthropic/claude-3-haiku-20240307 meta-llama/llama-3-70b-instruct", "/compare pick 2"},
+		Kind:     ArgModel,
+	}
+}
+
+// Complete suggests a model for the argument being typed
+func (c CompareCommand) Complete(args string) []Completion {
+	done := ""
+	current := args
+	if idx := strings.LastIndex(args, " "); idx >= 0 {
+		done, current = args[:idx+1], args[idx+1:]
+	}
+	if len(strings.Fields(done)) >= maxCompareModels {
+		return nil
+	}
+
+	completions := []Completion{}
+	for _, completion := range completeModels(current) {
+		completion.Value = done + completion.Value
+		completions = append(completions, completion)
+	}
+	return completions
+}
+
+// Execute executes the command
+func (c CompareCommand) Execute(args string) tea.Cmd {
+	if rest, ok := strings.CutPrefix(args, comparePick); ok && (rest == "" || rest[0] == ' ') {
+		return func() tea.Msg {
+			n, err := strconv.Atoi(strings.TrimSpace(rest))
+			if err != nil || n < 1 || n > maxCompareModels {
+				return messages.CommandResponseMsg(fmt.Sprintf("Usage: /compare pick N, where N is 1 to %d", maxCompareModels))
+			}
+			return messages.ComparePickMsg{Index: n}
+		}
+	}
+
+	models := strings.Fields(args)
+	if len(models) < minCompareModels || len(models) > maxCompareModels {
+		return func() tea.Msg {
+			return messages.CommandResponseMsg("Usage: /compare modelA modelB [modelC]")
+		}
+	}
+	return utils.CompareModels(models)
+}
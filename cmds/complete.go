@@ -0,0 +1,163 @@
+package cmds
+
+import (
+	"codeaid/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxPathCompletions limits the number of file suggestions shown at once
+const maxPathCompletions = 20
+
+// FindHints returns sorted completions for the current input. Before the
+// first space it completes command names; afterwards it completes the
+// command's arguments. Each Value is the full replacement input.
+func FindHints(input string) []Completion {
+	if !strings.HasPrefix(input, "/") {
+		return nil
+	}
+
+	// Complete the command name itself
+	idx := strings.Index(input, " ")
+	if idx < 0 {
+		hints := []Completion{}
+		for _, name := range FindMatchingCommands(input) {
+			hints = append(hints, Completion{
+				Value:       name,
+				Description: commandRegistry[name].Description(),
+			})
+		}
+		return hints
+	}
+
+	// Complete the arguments of a known command
+	cmd, ok := commandRegistry[input[:idx]]
+	if !ok {
+		return nil
+	}
+	args := strings.TrimLeft(input[idx+1:], " ")
+
+	var completions []Completion
+	if completer, ok := cmd.(Completer); ok {
+		completions = completer.Complete(args)
+	} else if provider, ok := cmd.(ArgSpecProvider); ok {
+		completions = completeFromSpec(provider.Args(), args)
+	}
+
+	hints := make([]Completion, 0, len(completions))
+	for _, completion := range completions {
+		hints = append(hints, Completion{
+			Value:       cmd.Name() + " " + completion.Value,
+			Description: completion.Description,
+		})
+	}
+	return hints
+}
+
+// completeFromSpec derives argument completions from an ArgSpec
+func completeFromSpec(spec ArgSpec, args string) []Completion {
+	// Offer subcommands while the first argument is being typed
+	if len(spec.Subcommands) > 0 && !strings.Contains(args, " ") {
+		return filterCompletions(spec.Subcommands, args)
+	}
+
+	switch spec.Kind {
+	case ArgFile:
+		return completePaths(args)
+	case ArgModel:
+		return completeModels(args)
+	case ArgCommand:
+		return completeCommands(args)
+	}
+	return nil
+}
+
+// filterCompletions returns the completions starting with prefix, sorted
+func filterCompletions(completions []Completion, prefix string) []Completion {
+	matches := []Completion{}
+	for _, completion := range completions {
+		if strings.HasPrefix(completion.Value, prefix) {
+			matches = append(matches, completion)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Value < matches[j].Value
+	})
+	return matches
+}
+
+// filterNumbered returns the completions starting with prefix in their
+// given order, so numbered items such as messages stay in sequence
+func filterNumbered(completions []Completion, prefix string) []Completion {
+	matches := []Completion{}
+	for _, completion := range completions {
+		if strings.HasPrefix(completion.Value, prefix) {
+			matches = append(matches, completion)
+		}
+	}
+	return matches
+}
+
+// completeModels suggests known model identifiers
+func completeModels(prefix string) []Completion {
+	completions := make([]Completion, 0, len(config.AvailableModels))
+	for _, model := range config.AvailableModels {
+		completions = append(completions, Completion{Value: model, Description: "model"})
+	}
+	return filterCompletions(completions, prefix)
+}
+
+// completeCommands suggests registered command names
+func completeCommands(prefix string) []Completion {
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+
+	completions := []Completion{}
+	for _, name := range FindMatchingCommands(prefix) {
+		completions = append(completions, Completion{
+			Value:       name,
+			Description: commandRegistry[name].Description(),
+		})
+	}
+	return completions
+}
+
+// completePaths suggests files and directories matching a path prefix
+func completePaths(prefix string) []Completion {
+	dir, base := filepath.Split(prefix)
+	searchDir := dir
+	if searchDir == "" {
+		searchDir = "."
+	}
+
+	entries, err := os.ReadDir(searchDir)
+	if err != nil {
+		return nil
+	}
+
+	completions := []Completion{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		// Hide dotfiles unless explicitly requested
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+
+		completion := Completion{Value: dir + name, Description: "file"}
+		if entry.IsDir() {
+			completion.Value += string(filepath.Separator)
+			completion.Description = "directory"
+		}
+		completions = append(completions, completion)
+		if len(completions) >= maxPathCompletions {
+			break
+		}
+	}
+	return completions
+}
@@ -0,0 +1,76 @@
+package cmds
+
+import (
+	"slices"
+	"testing"
+
+	"codeaid/config"
+	"codeaid/messages"
+	"codeaid/utils"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// useConversation answers each prompt with the fake provider, using an
+// empty configuration, then retries the last one to add a branch
+func useConversation(t *testing.T, prompts ...string) {
+	t.Helper()
+	config.Isolate(t.TempDir())
+	utils.UseFakeProvider()
+	utils.ClearHistory()
+	t.Cleanup(func() {
+		config.Isolate("")
+		utils.ClearHistory()
+	})
+
+	answer := func(cmd tea.Cmd) {
+		utils.QueueFakeReply(utils.FakeReply{Content: "ok"})
+		msg, ok := cmd().(messages.ResponseMsg)
+		if !ok {
+			t.Fatal("no reply")
+		}
+		utils.EndRequest(msg.RequestID)
+	}
+	for _, prompt := range prompts {
+		answer(utils.FetchReply(prompt))
+	}
+	if err := utils.RetryLast(); err != nil {
+		t.Fatal(err)
+	}
+	answer(utils.FetchPending())
+}
+
+func TestFindHints(t *testing.T) {
+	useConversation(t, "first question", "second question")
+
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"/con", []string{"/config", "/continue"}},
+		{"/config ", []string{"/config show"}},
+		{"/config x", []string{}},
+		{"/help /ed", []string{"/help /edit", "/help /editor"}},
+		{"/edit ", []string{"/edit 1", "/edit 2"}},
+		{"/edit 2", []string{"/edit 2"}},
+		{"/branches ", []string{"/branches 2"}},
+		{"/branches 2 ", []string{"/branches 2 1", "/branches 2 2"}},
+		{"/branches 1 ", []string{}},
+		{"/branches 2 1 ", []string{}},
+		{"/nope x", []string{}},
+		{"hello", []string{}},
+	}
+	for _, test := range tests {
+		got := []string{}
+		for _, hint := range FindHints(test.input) {
+			got = append(got, hint.Value)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("FindHints(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+
+	if hints := FindHints("/edit 1"); len(hints) != 1 || hints[0].Description != "first question" {
+		t.Errorf("FindHints(/edit 1) = %+v, want the message as its description", hints)
+	}
+}
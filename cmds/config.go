@@ -5,6 +5,8 @@ import (
 	"codeaid/messages"
 	"codeaid/utils"
 	"fmt"
+	"sort"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -22,8 +24,23 @@ func (c ConfigCommand) Description() string {
 	return "Update configuration settings"
 }
 
+// Args describes the command arguments
+func (c ConfigCommand) Args() ArgSpec {
+	return ArgSpec{
+		Usage:    "/config [show]",
+		Examples: []string{"/config", "/config show"},
+		Subcommands: []Completion{
+			{Value: "show", Description: "Show effective values and where they come from"},
+		},
+	}
+}
+
 // Execute executes the command
 func (c ConfigCommand) Execute(args string) tea.Cmd {
+	if args == "show" {
+		return showConfig
+	}
+
 	return func() tea.Msg {
 		// Load current configuration
 		cfg, err := config.Load()
@@ -43,4 +60,62 @@ func (c ConfigCommand) Execute(args string) tea.Cmd {
 			ConfigStep:   "api_key",
 		}
 	}
-}
\ No newline at end of file
+}
+
+// showConfig lists each effective setting and the layer it came from
+func showConfig() tea.Msg {
+	resolved, err := config.Resolve()
+	if err != nil {
+		return messages.CommandResponseMsg(fmt.Sprintf("Error loading configuration: %v", err))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Effective configuration:\n")
+	for _, key := range config.Keys() {
+		if _, ok := resolved.Sources[key]; !ok {
+			continue
+		}
+		value := resolved.Value(key)
+		switch key {
+		case "openrouter_api_key":
+			value = utils.MaskAPIKey(value)
+		case "profiles":
+			// Only list names; profiles may contain API keys
+			value = strings.Join(resolved.ProfileNames(), ", ")
+		case "http":
+			// Header values may contain credentials
+			value = describeHTTP(resolved.Data.HTTP)
+		}
+		sb.WriteString(fmt.Sprintf("%s = %s  (%s)\n", key, value, resolved.Source(key)))
+	}
+	return messages.CommandResponseMsg(sb.String())
+}
+
+// describeHTTP summarises the http settings without header values
+func describeHTTP(h *config.HTTPConfig) string {
+	parts := []string{}
+	if h.Proxy != "" {
+		parts = append(parts, "proxy "+h.Proxy)
+	}
+	if h.CABundle != "" {
+		parts = append(parts, "ca_bundle "+h.CABundle)
+	}
+	if h.ClientCert != "" {
+		parts = append(parts, "client_cert "+h.ClientCert)
+	}
+	if h.Timeout > 0 {
+		parts = append(parts, fmt.Sprintf("timeout %ds", h.Timeout))
+	}
+	if h.ConnectTimeout > 0 {
+		parts = append(parts, fmt.Sprintf("connect_timeout %ds", h.ConnectTimeout))
+	}
+	if len(h.Headers) > 0 {
+		names := make([]string, 0, len(h.Headers))
+		for name := range h.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		parts = append(parts, "headers "+strings.Join(names, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
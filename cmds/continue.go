@@ -0,0 +1,30 @@
+package cmds
+
+import (
+	"codeaid/utils"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ContinueCommand resumes a reply that was cut off at the token limit
+type ContinueCommand struct{}
+
+// Name returns the command name
+func (c ContinueCommand) Name() string {
+	return "/continue"
+}
+
+// Description returns the command description
+func (c ContinueCommand) Description() string {
+	return "Resume the last reply where it stopped"
+}
+
+// Args describes the command arguments
+func (c ContinueCommand) Args() ArgSpec {
+	return ArgSpec{Usage: "/continue"}
+}
+
+// Execute executes the command
+func (c ContinueCommand) Execute(args string) tea.Cmd {
+	return utils.ContinueLast()
+}
@@ -0,0 +1,81 @@
+package cmds
+
+import (
+	"codeaid/messages"
+	"codeaid/utils"
+	"encoding/json"
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxDebugDisplay limits how much of an exchange is shown in the chat
+const maxDebugDisplay = 20000
+
+// DebugCommand shows the debug log status and the last API exchange
+type DebugCommand struct{}
+
+// Name returns the command name
+func (c DebugCommand) Name() string {
+	return "/debug"
+}
+
+// Description returns the command description
+func (c DebugCommand) Description() string {
+	return "Show debug logging status or the last API exchange"
+}
+
+// Args describes the command arguments
+func (c DebugCommand) Args() ArgSpec {
+	return ArgSpec{
+		Usage:    "/debug [last]",
+		Examples: []string{"/debug", "/debug last"},
+		Subcommands: []Completion{
+			{Value: "last", Description: "Show the last request and response, secrets redacted"},
+		},
+	}
+}
+
+// Execute executes the command
+func (c DebugCommand) Execute(args string) tea.Cmd {
+	return func() tea.Msg {
+		switch args {
+		case "":
+			return debugStatus()
+		case "last":
+			return lastExchange()
+		}
+		return messages.CommandResponseMsg("Usage: /debug [last]")
+	}
+}
+
+// debugStatus reports whether debug logging is on and where it writes
+func debugStatus() tea.Msg {
+	path, err := utils.GetDebugLogPath()
+	if err != nil {
+		return messages.CommandResponseMsg(fmt.Sprintf("Error: %v", err))
+	}
+	if !utils.DebugEnabled() {
+		return messages.CommandResponseMsg("Debug logging is off. Start with --debug or set CODEAID_DEBUG=true to log to " + path)
+	}
+	return messages.CommandResponseMsg("Debug logging is on: " + path)
+}
+
+// lastExchange shows the most recent API exchange as JSON
+func lastExchange() tea.Msg {
+	exchange, ok := utils.LastExchange()
+	if !ok {
+		return messages.CommandResponseMsg("No API requests yet")
+	}
+
+	data, err := json.MarshalIndent(exchange, "", "  ")
+	if err != nil {
+		return messages.CommandResponseMsg(fmt.Sprintf("Error: %v", err))
+	}
+
+	text := string(data)
+	if len(text) > maxDebugDisplay {
+		text = text[:maxDebugDisplay] + "\n... (truncated)"
+	}
+	return messages.CommandResponseMsg(text)
+}
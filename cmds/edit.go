@@ -0,0 +1,62 @@
+package cmds
+
+import (
+	"codeaid/messages"
+	"codeaid/utils"
+	"fmt"
+	"strconv"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// EditCommand loads an earlier message into the input box to edit and resend
+type EditCommand struct{}
+
+// Name returns the command name
+func (c EditCommand) Name() string {
+	return "/edit"
+}
+
+// Description returns the command description
+func (c EditCommand) Description() string {
+	return "Edit and resend an earlier message"
+}
+
+// Args describes the command arguments
+func (c EditCommand) Args() ArgSpec {
+	return ArgSpec{
+		Usage:    "/edit [N]",
+		Examples: []string{"/edit", "/edit 2"},
+	}
+}
+
+// Complete suggests the numbers of the messages on the active branch
+func (c EditCommand) Complete(args string) []Completion {
+	completions := []Completion{}
+	for i := 1; i <= utils.PromptCount(); i++ {
+		if _, prompt, err := utils.PromptAt(i); err == nil {
+			completions = append(completions, Completion{Value: strconv.Itoa(i), Description: preview(prompt)})
+		}
+	}
+	return filterNumbered(completions, args)
+}
+
+// Execute executes the command
+func (c EditCommand) Execute(args string) tea.Cmd {
+	return func() tea.Msg {
+		index := 0
+		if args != "" {
+			n, err := strconv.Atoi(args)
+			if err != nil || n < 1 {
+				return messages.CommandResponseMsg(fmt.Sprintf("Error: invalid message number %q", args))
+			}
+			index = n
+		}
+
+		index, prompt, err := utils.PromptAt(index)
+		if err != nil {
+			return messages.CommandResponseMsg(fmt.Sprintf("Error: %v", err))
+		}
+		return messages.EditMsg{Index: index, Prompt: prompt}
+	}
+}
@@ -0,0 +1,50 @@
+package cmds
+
+import (
+	"codeaid/messages"
+	"codeaid/utils"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// EditorCommand composes a prompt in the external editor
+type EditorCommand struct{}
+
+// Name returns the command name
+func (c EditorCommand) Name() string {
+	return "/editor"
+}
+
+// Description returns the command description
+func (c EditorCommand) Description() string {
+	return "Compose the prompt in $VISUAL or $EDITOR"
+}
+
+// Args describes the command arguments
+func (c EditorCommand) Args() ArgSpec {
+	return ArgSpec{
+		Usage:    "/editor [quote]",
+		Examples: []string{"/editor", "/editor quote"},
+		Subcommands: []Completion{
+			{Value: "quote", Description: "Start with the last reply quoted"},
+		},
+	}
+}
+
+// NeedsTerminal reports that the editor takes over the terminal
+func (c EditorCommand) NeedsTerminal() bool {
+	return true
+}
+
+// Execute executes the command
+func (c EditorCommand) Execute(args string) tea.Cmd {
+	switch args {
+	case "":
+		return utils.OpenEditor("", false, true)
+	case "quote":
+		return utils.OpenEditor("", true, true)
+	}
+	return func() tea.Msg {
+		return messages.CommandResponseMsg("Usage: /editor [quote]")
+	}
+}
@@ -17,6 +17,11 @@ func (c ExitCommand) Description() string {
 	return "Exit the application"
 }
 
+// Args describes the command arguments
+func (c ExitCommand) Args() ArgSpec {
+	return ArgSpec{Usage: "/exit"}
+}
+
 // Execute executes the command
 func (c ExitCommand) Execute(args string) tea.Cmd {
 	return tea.Quit
@@ -2,6 +2,8 @@ package cmds
 
 import (
 	"codeaid/messages"
+	"fmt"
+
 	tea "github.com/charmbracelet/bubbletea"
 )
 
@@ -18,9 +20,45 @@ func (c HelpCommand) Description() string {
 	return "Show available commands"
 }
 
+// Args describes the command arguments
+func (c HelpCommand) Args() ArgSpec {
+	return ArgSpec{
+		Usage:    "/help [command]",
+		Examples: []string{"/help", "/help config"},
+		Kind:     ArgCommand,
+	}
+}
+
 // Execute executes the command
 func (c HelpCommand) Execute(args string) tea.Cmd {
 	return func() tea.Msg {
+		// Show detailed help for a single command
+		if args != "" {
+			cmd, ok := LookupCommand(args)
+			if !ok {
+				return messages.CommandResponseMsg(fmt.Sprintf("Unknown command: %s", args))
+			}
+
+			helpMsg := messages.HelpMsg{
+				Header: cmd.Name() + " - " + cmd.Description(),
+				Usage:  cmd.Name(),
+			}
+			if provider, ok := cmd.(ArgSpecProvider); ok {
+				spec := provider.Args()
+				if spec.Usage != "" {
+					helpMsg.Usage = spec.Usage
+				}
+				helpMsg.Examples = spec.Examples
+				for _, sub := range spec.Subcommands {
+					helpMsg.Commands = append(helpMsg.Commands, messages.CommandInfo{
+						Name:        sub.Value,
+						Description: sub.Description,
+					})
+				}
+			}
+			return helpMsg
+		}
+
 		// Collect all commands
 		allCommands := GetAllCommands()
 		cmdInfos := make([]messages.CommandInfo, 0, len(allCommands))
@@ -39,4 +77,3 @@ func (c HelpCommand) Execute(args string) tea.Cmd {
 		}
 	}
 }
-
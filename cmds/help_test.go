@@ -0,0 +1,38 @@
+package cmds
+
+import (
+	"slices"
+	"testing"
+
+	"codeaid/messages"
+)
+
+func TestHelpUsage(t *testing.T) {
+	tests := []struct {
+		args        string
+		usage       string
+		examples    []string
+		subcommands []string
+	}{
+		{"edit", "/edit [N]", []string{"/edit", "/edit 2"}, nil},
+		{"/config", "/config [show]", []string{"/config", "/config show"}, []string{"show"}},
+		{"keys", "/keys", nil, nil},
+	}
+	for _, test := range tests {
+		msg, ok := HelpCommand{}.Execute(test.args)().(messages.HelpMsg)
+		if !ok {
+			t.Fatalf("/help %s did not return help", test.args)
+		}
+		var subcommands []string
+		for _, sub := range msg.Commands {
+			subcommands = append(subcommands, sub.Name)
+		}
+		if msg.Usage != test.usage || !slices.Equal(msg.Examples, test.examples) || !slices.Equal(subcommands, test.subcommands) {
+			t.Errorf("/help %s: usage %q, examples %q, subcommands %q", test.args, msg.Usage, msg.Examples, subcommands)
+		}
+	}
+
+	if msg := (HelpCommand{}).Execute("nope")(); msg != messages.CommandResponseMsg("Unknown command: nope") {
+		t.Errorf("/help nope = %#v, want an unknown command error", msg)
+	}
+}
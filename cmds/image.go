@@ -0,0 +1,66 @@
+package cmds
+
+import (
+	"codeaid/messages"
+	"codeaid/utils"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ImageCommand attaches an image to the next message
+type ImageCommand struct{}
+
+// Name returns the command name
+func (c ImageCommand) Name() string {
+	return "/image"
+}
+
+// Description returns the command description
+func (c ImageCommand) Description() string {
+	return "Attach an image to the next message"
+}
+
+// Args describes the command arguments
+func (c ImageCommand) Args() ArgSpec {
+	return ArgSpec{
+		Usage:    "/image [path|clear]",
+		Examples: []string{"/image screenshot.png", "/image clear", "What is wrong here? @screenshot.png"},
+		Kind:     ArgFile,
+	}
+}
+
+// Complete suggests image files and the clear subcommand
+func (c ImageCommand) Complete(args string) []Completion {
+	completions := filterCompletions([]Completion{{Value: "clear", Description: "Remove attached images"}}, args)
+	for _, completion := range completePaths(args) {
+		if completion.Description == "directory" || utils.IsImagePath(completion.Value) {
+			completions = append(completions, completion)
+		}
+	}
+	return completions
+}
+
+// Execute executes the command
+func (c ImageCommand) Execute(args string) tea.Cmd {
+	return func() tea.Msg {
+		switch args {
+		case "":
+			staged := utils.StagedAttachments()
+			if len(staged) == 0 {
+				return messages.CommandResponseMsg("No images attached. Use /image path or @path in a message.")
+			}
+			return messages.CommandResponseMsg("Attached to the next message: " + strings.Join(staged, ", "))
+		case "clear":
+			utils.ClearAttachments()
+			return messages.CommandResponseMsg("Removed attached images")
+		}
+
+		attachment, err := utils.AttachImage(args)
+		if err != nil {
+			return messages.CommandResponseMsg(fmt.Sprintf("Error: %v", err))
+		}
+		return messages.CommandResponseMsg(fmt.Sprintf("Attached %s; it will be sent with your next message", attachment.Path))
+	}
+}
@@ -0,0 +1,66 @@
+package cmds
+
+import (
+	"codeaid/config"
+	"codeaid/editor"
+	"codeaid/keys"
+	"codeaid/messages"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// KeysCommand lists the effective key bindings
+type KeysCommand struct{}
+
+// Name returns the command name
+func (c KeysCommand) Name() string {
+	return "/keys"
+}
+
+// Description returns the command description
+func (c KeysCommand) Description() string {
+	return "List key bindings"
+}
+
+// Execute executes the command
+func (c KeysCommand) Execute(args string) tea.Cmd {
+	return func() tea.Msg {
+		var sb strings.Builder
+		sb.WriteString("Key bindings:\n")
+		for _, binding := range keys.Current().Bindings() {
+			bound := "(unbound)"
+			if len(binding.Keys) > 0 {
+				names := make([]string, len(binding.Keys))
+				for i, key := range binding.Keys {
+					names[i] = strings.ReplaceAll(key, " ", "space")
+				}
+				bound = strings.Join(names, ", ")
+			}
+			sb.WriteString(fmt.Sprintf("  %-15s %-20s %s\n", binding.Action, bound, binding.Description))
+		}
+
+		// Editing modes see keys before the bindings above
+		if resolved, err := config.Resolve(); err == nil {
+			switch editor.ParseMode(resolved.Data.EditMode) {
+			case editor.ModeVi:
+				sb.WriteString("\nEdit mode vi: Esc for normal mode; i a I A, h j k l, w b e, 0 $, x, dd dw cw cc, p P, u\n")
+			case editor.ModeEmacs:
+				sb.WriteString("\nEdit mode emacs: ctrl+a/e/f/b, alt+f/b, ctrl+k/y take precedence over the bindings above; open the editor with /editor or rebind it\n")
+			}
+		}
+
+		if warnings := keys.Warnings(); len(warnings) > 0 {
+			sb.WriteString("\nProblems:\n")
+			for _, warning := range warnings {
+				sb.WriteString("  " + warning + "\n")
+			}
+		}
+
+		if path, err := keys.GetKeymapPath(); err == nil {
+			sb.WriteString(fmt.Sprintf("\nChange bindings in %s, e.g. {\"quit\": [\"ctrl+q\"], \"copy\": [\"ctrl+y\"]}", path))
+		}
+		return messages.CommandResponseMsg(sb.String())
+	}
+}
@@ -0,0 +1,300 @@
+package cmds
+
+import (
+	"bytes"
+	"codeaid/config"
+	"codeaid/messages"
+	"codeaid/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Plugin executable naming and timeouts
+const (
+	pluginPrefix          = "codeaid-"
+	pluginDescribeTimeout = 2 * time.Second
+	pluginExecuteTimeout  = 60 * time.Second
+)
+
+// pluginRoles are the roles a plugin may give the messages it injects.
+// Other roles, such as tool, need fields the protocol does not carry and
+// would make every later request fail.
+var pluginRoles = []string{
+	openai.ChatMessageRoleSystem,
+	openai.ChatMessageRoleUser,
+	openai.ChatMessageRoleAssistant,
+}
+
+// PluginMessage is a single conversation message in the plugin protocol
+type PluginMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// PluginRequest is the JSON document written to a plugin's stdin
+type PluginRequest struct {
+	Args         string          `json:"args"`
+	Conversation []PluginMessage `json:"conversation"`
+	WorkingDir   string          `json:"working_dir"`
+}
+
+// PluginResponse is the JSON document a plugin writes to stdout
+type PluginResponse struct {
+	Display  string          `json:"display,omitempty"`
+	Messages []PluginMessage `json:"messages,omitempty"`
+	Prompt   string          `json:"prompt,omitempty"`
+}
+
+// PluginCommand runs an external plugin executable. Plugins are executables
+// named codeaid-<name> found in the plugins directory
+// (~/.config/codeaid/plugins) or on PATH. Each one becomes the slash
+// command /<name>.
+//
+// Discovery handshake: the executable is run with a single --describe
+// argument and the first line it prints is used as the command description.
+// The handshakes run in the background and each is limited to
+// pluginDescribeTimeout, so plugins never delay startup.
+//
+// Execution protocol: the executable is run with no arguments and receives a
+// JSON request on stdin:
+//
+//	{
+//	  "args": "everything after the command name",
+//	  "conversation": [{"role": "user", "content": "..."}],
+//	  "working_dir": "/current/working/directory"
+//	}
+//
+// It must print a JSON response on stdout. Every field is optional:
+//
+//	{
+//	  "display": "text shown in the chat, not sent to the model",
+//	  "messages": [{"role": "system", "content": "added to the conversation"}],
+//	  "prompt": "sent to the model as if the user had typed it"
+//	}
+//
+// Injected messages must have the role system, user or assistant.
+//
+// A non-zero exit status is reported as an error together with stderr.
+type PluginCommand struct {
+	name        string
+	path        string
+	description func() string // Runs the handshake once and waits for it
+}
+
+// Name returns the command name
+func (c PluginCommand) Name() string {
+	return c.name
+}
+
+// Description returns the command description
+func (c PluginCommand) Description() string {
+	return c.description()
+}
+
+// Path returns the path of the plugin executable
+func (c PluginCommand) Path() string {
+	return c.path
+}
+
+// Args describes the command arguments
+func (c PluginCommand) Args() ArgSpec {
+	return ArgSpec{
+		Usage: c.name + " [args]",
+		Kind:  ArgFile,
+	}
+}
+
+// Execute executes the command
+func (c PluginCommand) Execute(args string) tea.Cmd {
+	return func() tea.Msg {
+		done := utils.StartTool("/" + c.name)
+		resp, err := c.run(args)
+		done()
+		if err != nil {
+			return messages.CommandResponseMsg(fmt.Sprintf("Error running %s: %v", c.name, err))
+		}
+
+		// Add injected messages to the conversation history
+		if len(resp.Messages) > 0 {
+			injected := make([]openai.ChatCompletionMessage, 0, len(resp.Messages))
+			for _, msg := range resp.Messages {
+				injected = append(injected, openai.ChatCompletionMessage{
+					Role:    msg.Role,
+					Content: msg.Content,
+				})
+			}
+			utils.InjectMessages(injected)
+		}
+
+		return messages.PluginMsg{
+			Display: resp.Display,
+			Prompt:  resp.Prompt,
+		}
+	}
+}
+
+// run invokes the plugin executable with the JSON protocol
+func (c PluginCommand) run(args string) (*PluginResponse, error) {
+	workingDir, _ := os.Getwd()
+
+	history := utils.GetHistory()
+	conversation := make([]PluginMessage, 0, len(history))
+	for _, msg := range history {
+		content := msg.Content
+		for _, part := range msg.MultiContent {
+			// Plugins receive the text of messages with image attachments
+			if part.Type == openai.ChatMessagePartTypeText {
+				content = part.Text
+			}
+		}
+		conversation = append(conversation, PluginMessage{Role: msg.Role, Content: content})
+	}
+
+	request, err := json.Marshal(PluginRequest{
+		Args:         args,
+		Conversation: conversation,
+		WorkingDir:   workingDir,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pluginExecuteTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.path)
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Dir = workingDir
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	var resp PluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("invalid plugin response: %v", err)
+	}
+	for i, msg := range resp.Messages {
+		if !slices.Contains(pluginRoles, msg.Role) {
+			return nil, fmt.Errorf("invalid plugin response: message %d has role %q, expected one of %s",
+				i+1, msg.Role, strings.Join(pluginRoles, ", "))
+		}
+	}
+	return &resp, nil
+}
+
+// GetPluginDir returns the directory searched for plugins before PATH
+func GetPluginDir() (string, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "plugins"), nil
+}
+
+// LoadPlugins discovers plugin executables and registers them as commands.
+// Built-in commands and earlier search directories take precedence.
+func LoadPlugins() {
+	dirs := []string{}
+	if pluginDir, err := GetPluginDir(); err == nil {
+		dirs = append(dirs, pluginDir)
+	}
+	dirs = append(dirs, filepath.SplitList(os.Getenv("PATH"))...)
+
+	for _, dir := range dirs {
+		for _, plugin := range discoverPlugins(dir) {
+			if _, exists := commandRegistry[plugin.name]; exists {
+				continue
+			}
+			path := plugin.path
+			plugin.description = sync.OnceValue(func() string { return describePlugin(path) })
+			go plugin.description()
+			RegisterCommand(plugin)
+		}
+	}
+}
+
+// discoverPlugins lists the plugin executables in a directory
+func discoverPlugins(dir string) []PluginCommand {
+	if dir == "" {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	plugins := []PluginCommand{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(fileName, pluginPrefix) {
+			continue
+		}
+
+		path := filepath.Join(dir, fileName)
+		if !isExecutable(path) {
+			continue
+		}
+
+		name := strings.TrimPrefix(fileName, pluginPrefix)
+		if runtime.GOOS == "windows" {
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+		}
+		if name == "" {
+			continue
+		}
+
+		plugins = append(plugins, PluginCommand{name: "/" + name, path: path})
+	}
+	return plugins
+}
+
+// describePlugin runs the --describe handshake and returns the description
+func describePlugin(path string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginDescribeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path, "--describe")
+	// Stop waiting for output once the plugin is killed, even if a child
+	// process still holds stdout open
+	cmd.WaitDelay = 100 * time.Millisecond
+	output, err := cmd.Output()
+	if err == nil {
+		description, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+		if description != "" {
+			return strings.TrimSpace(description)
+		}
+	}
+	return "Plugin " + filepath.Base(path)
+}
+
+// isExecutable reports whether path is a regular file the user can execute
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(filepath.Ext(path), ".exe")
+	}
+	return info.Mode().Perm()&0111 != 0
+}
@@ -0,0 +1,101 @@
+package cmds
+
+import (
+	"codeaid/config"
+	"codeaid/messages"
+	"codeaid/utils"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// noProfile is the argument that deactivates the current profile
+const noProfile = "none"
+
+// ProfileCommand lists or switches configuration profiles
+type ProfileCommand struct{}
+
+// Name returns the command name
+func (c ProfileCommand) Name() string {
+	return "/profile"
+}
+
+// Description returns the command description
+func (c ProfileCommand) Description() string {
+	return "List or switch configuration profiles"
+}
+
+// Args describes the command arguments
+func (c ProfileCommand) Args() ArgSpec {
+	return ArgSpec{
+		Usage:    "/profile [name|none]",
+		Examples: []string{"/profile", "/profile work", "/profile none"},
+	}
+}
+
+// Complete suggests configured profile names
+func (c ProfileCommand) Complete(args string) []Completion {
+	resolved, err := config.Resolve()
+	if err != nil {
+		return nil
+	}
+
+	completions := []Completion{{Value: noProfile, Description: "Use the top-level settings"}}
+	for _, name := range resolved.ProfileNames() {
+		profile := resolved.Data.Profiles[name]
+		completions = append(completions, Completion{Value: name, Description: profile.Model})
+	}
+	return filterCompletions(completions, args)
+}
+
+// Execute executes the command
+func (c ProfileCommand) Execute(args string) tea.Cmd {
+	return func() tea.Msg {
+		if args == "" {
+			return listProfiles()
+		}
+
+		name := args
+		if name == noProfile {
+			name = ""
+		}
+		if err := utils.SwitchProfile(name); err != nil {
+			return messages.CommandResponseMsg(fmt.Sprintf("Error: %v", err))
+		}
+
+		return messages.ProfileMsg{
+			Name:  utils.ActiveProfile(),
+			Model: utils.GetModel(),
+		}
+	}
+}
+
+// listProfiles shows the configured profiles with the active one marked
+func listProfiles() tea.Msg {
+	resolved, err := config.Resolve()
+	if err != nil {
+		return messages.CommandResponseMsg(fmt.Sprintf("Error loading configuration: %v", err))
+	}
+
+	names := resolved.ProfileNames()
+	if len(names) == 0 {
+		return messages.CommandResponseMsg("No profiles configured. Add them under \"profiles\" in config.json.")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Profiles:\n")
+	for _, name := range names {
+		marker := "  "
+		if name == resolved.Data.Profile {
+			marker = "* "
+		}
+		profile := resolved.Data.Profiles[name]
+		provider := profile.Provider
+		if provider == "" {
+			provider = config.ProviderOpenRouter
+		}
+		sb.WriteString(fmt.Sprintf("%s%s (%s, %s)\n", marker, name, provider, profile.Model))
+	}
+	return messages.CommandResponseMsg(sb.String())
+}
@@ -2,6 +2,7 @@ package cmds
 
 import (
 	"codeaid/utils"
+	"sort"
 	"strings"
 )
 
@@ -15,6 +16,18 @@ func init() {
 	RegisterCommand(ExitCommand{})
 	RegisterCommand(HelpCommand{})
 	RegisterCommand(ConfigCommand{})
+	RegisterCommand(ProfileCommand{})
+	RegisterCommand(RetryCommand{})
+	RegisterCommand(ContinueCommand{})
+	RegisterCommand(EditCommand{})
+	RegisterCommand(BranchesCommand{})
+	RegisterCommand(UndoCommand{})
+	RegisterCommand(CompareCommand{})
+	RegisterCommand(ImageCommand{})
+	RegisterCommand(DebugCommand{})
+	RegisterCommand(ThemeCommand{})
+	RegisterCommand(KeysCommand{})
+	RegisterCommand(EditorCommand{})
 }
 
 // RegisterCommand adds a command to the registry
@@ -31,25 +44,37 @@ func GetCommand(name string) utils.CommandExecutor {
 	return cmd
 }
 
-// GetAllCommands returns all registered commands
+// GetAllCommands returns all registered commands sorted by name
 func GetAllCommands() []Command {
 	cmds := make([]Command, 0, len(commandRegistry))
-	for _, cmd := range commandRegistry {
-		cmds = append(cmds, cmd)
+	for _, name := range GetCommandNames() {
+		cmds = append(cmds, commandRegistry[name])
 	}
 	return cmds
 }
 
-// GetCommandNames returns all command names
+// GetCommandNames returns all command names in sorted order
 func GetCommandNames() []string {
 	names := make([]string, 0, len(commandRegistry))
 	for name := range commandRegistry {
 		names = append(names, name)
 	}
+	sort.Strings(names)
 	return names
 }
 
-// FindMatchingCommands returns all commands that start with the given prefix
+// LookupCommand returns a registered command by name, with or without the
+// leading slash
+func LookupCommand(name string) (Command, bool) {
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	cmd, ok := commandRegistry[name]
+	return cmd, ok
+}
+
+// FindMatchingCommands returns all commands that start with the given prefix,
+// sorted by name
 func FindMatchingCommands(prefix string) []string {
 	if prefix == "" {
 		return nil
@@ -66,6 +91,7 @@ func FindMatchingCommands(prefix string) []string {
 			matches = append(matches, name)
 		}
 	}
+	sort.Strings(matches)
 	return matches
 }
 
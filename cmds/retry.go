@@ -0,0 +1,37 @@
+package cmds
+
+import (
+	"codeaid/messages"
+	"codeaid/utils"
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// RetryCommand regenerates the last answer on a new branch
+type RetryCommand struct{}
+
+// Name returns the command name
+func (c RetryCommand) Name() string {
+	return "/retry"
+}
+
+// Description returns the command description
+func (c RetryCommand) Description() string {
+	return "Regenerate the last answer"
+}
+
+// Args describes the command arguments
+func (c RetryCommand) Args() ArgSpec {
+	return ArgSpec{Usage: "/retry"}
+}
+
+// Execute executes the command
+func (c RetryCommand) Execute(args string) tea.Cmd {
+	return func() tea.Msg {
+		if err := utils.RetryLast(); err != nil {
+			return messages.CommandResponseMsg(fmt.Sprintf("Error: %v", err))
+		}
+		return messages.HistoryChangedMsg{Fetch: true}
+	}
+}
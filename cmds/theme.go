@@ -0,0 +1,83 @@
+package cmds
+
+import (
+	"codeaid/messages"
+	"codeaid/theme"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ThemeCommand previews the available themes and switches between them
+type ThemeCommand struct{}
+
+// Name returns the command name
+func (c ThemeCommand) Name() string {
+	return "/theme"
+}
+
+// Description returns the command description
+func (c ThemeCommand) Description() string {
+	return "Preview themes or switch theme for this session"
+}
+
+// Args describes the command arguments
+func (c ThemeCommand) Args() ArgSpec {
+	return ArgSpec{
+		Usage:    "/theme [name]",
+		Examples: []string{"/theme", "/theme light", "/theme auto"},
+	}
+}
+
+// Complete suggests theme names
+func (c ThemeCommand) Complete(args string) []Completion {
+	completions := []Completion{}
+	for _, name := range theme.Names() {
+		if strings.HasPrefix(name, args) {
+			completions = append(completions, Completion{Value: name})
+		}
+	}
+	return completions
+}
+
+// Execute executes the command
+func (c ThemeCommand) Execute(args string) tea.Cmd {
+	return func() tea.Msg {
+		if args == "" {
+			return messages.CommandResponseMsg(listThemes())
+		}
+		if err := theme.Use(args); err != nil {
+			return messages.CommandResponseMsg(fmt.Sprintf("Error: %v", err))
+		}
+		return messages.CommandResponseMsg(fmt.Sprintf("Switched to theme %s for this session. Set \"theme\" in the config file to keep it.", args))
+	}
+}
+
+// listThemes shows every theme with a sample of its colours
+func listThemes() string {
+	current := theme.CurrentName()
+
+	var sb strings.Builder
+	sb.WriteString("Themes:\n")
+	for _, name := range theme.Names() {
+		marker := "  "
+		if name == current {
+			marker = "* "
+		}
+		loaded, err := theme.Load(name)
+		if err != nil {
+			sb.WriteString(fmt.Sprintf("%s%-12s error: %v\n", marker, name, err))
+			continue
+		}
+		label := name
+		if name == theme.Auto {
+			label = fmt.Sprintf("%s (%s)", name, loaded.Name)
+		}
+		sb.WriteString(fmt.Sprintf("%s%-18s %s\n", marker, label, loaded.Swatch()))
+	}
+	if dir, err := theme.GetThemeDir(); err == nil {
+		sb.WriteString("\nUser themes are read from " + dir + "/<name>.json")
+	}
+	return sb.String()
+}
@@ -0,0 +1,41 @@
+package cmds
+
+import (
+	"codeaid/messages"
+	"codeaid/utils"
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// UndoCommand removes the last exchange from the conversation
+type UndoCommand struct{}
+
+// Name returns the command name
+func (c UndoCommand) Name() string {
+	return "/undo"
+}
+
+// Description returns the command description
+func (c UndoCommand) Description() string {
+	return "Remove the last exchange"
+}
+
+// Args describes the command arguments
+func (c UndoCommand) Args() ArgSpec {
+	return ArgSpec{Usage: "/undo"}
+}
+
+// Execute executes the command
+func (c UndoCommand) Execute(args string) tea.Cmd {
+	return func() tea.Msg {
+		turn, edited, err := utils.UndoLast()
+		if err != nil {
+			return messages.CommandResponseMsg(fmt.Sprintf("Error: %v", err))
+		}
+		if edited {
+			return messages.CommandResponseMsg(fmt.Sprintf("Removed: %s\nAn edited version of this message is now shown; see /branches", preview(turn.Prompt)))
+		}
+		return messages.CommandResponseMsg(fmt.Sprintf("Removed: %s", preview(turn.Prompt)))
+	}
+}
@@ -4,12 +4,51 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 // Data represents the application configuration
 type Data struct {
+	Version          int    `json:"version"`
 	OpenRouterAPIKey string `json:"openrouter_api_key"`
 	Model            string `json:"model"`
+
+	// Profile selects one of Profiles; Profiles hold named connection settings
+	Profile  string             `json:"profile,omitempty"`
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+
+	// VisionModels lists extra model identifiers, or parts of them, that
+	// accept image attachments
+	VisionModels []string `json:"vision_models,omitempty"`
+
+	// Debug writes every API exchange to a log file in the config directory;
+	// SecretPatterns are extra regular expressions redacted from that log
+	Debug          bool     `json:"debug,omitempty"`
+	SecretPatterns []string `json:"secret_patterns,omitempty"`
+
+	// HTTP configures proxies, certificates, timeouts and extra headers
+	HTTP *HTTPConfig `json:"http,omitempty"`
+
+	// Theme names a built-in theme or a file in the themes directory; auto
+	// picks a dark or light theme from the terminal background
+	Theme string `json:"theme,omitempty"`
+
+	// EditMode selects default, vi or emacs key handling for the input box
+	EditMode string `json:"edit_mode,omitempty"`
+
+	// StatusBar lists the items of the status line below the input, in
+	// order; "none" hides it. SessionName labels this session there.
+	StatusBar   []string `json:"status_bar,omitempty"`
+	SessionName string   `json:"session_name,omitempty"`
+
+	// ContextLimits and Prices add or override context windows in tokens
+	// and prices per million tokens, keyed by part of the model identifier
+	ContextLimits map[string]int   `json:"context_limits,omitempty"`
+	Prices        map[string]Price `json:"prices,omitempty"`
+
+	// Notifications lists, per event, how to notify while the terminal is
+	// unfocused; an empty list turns an event's notifications off
+	Notifications map[string][]string `json:"notifications,omitempty"`
 }
 
 // Model constants
@@ -27,6 +66,21 @@ var AvailableModels = []string{
 	"meta-llama/llama-3-70b-instruct",
 }
 
+// StatusBarItems are the items the status line can show, in their
+// default order
+var StatusBarItems = []string{"model", "profile", "session", "tokens", "cost", "state", "branch", "cwd"}
+
+// Notification events and the ways to announce them: a terminal bell, an
+// OSC 9 or OSC 777 desktop notification, or a marker in the window title
+var (
+	NotificationEvents  = []string{"reply", "error"}
+	NotificationMethods = []string{"bell", "osc9", "osc777", "title"}
+)
+
+// defaultNotifications is used for events the notifications setting
+// leaves out
+var defaultNotifications = []string{"bell", "title"}
+
 // DefaultModel returns the default model identifier
 func DefaultModel() string {
 	return DefaultModelName
@@ -34,6 +88,10 @@ func DefaultModel() string {
 
 // GetConfigDir returns the configuration directory path
 func GetConfigDir() (string, error) {
+	if dir, ok := isolatedDir(); ok {
+		return dir, nil
+	}
+
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
@@ -43,6 +101,32 @@ func GetConfigDir() (string, error) {
 	return configDir, nil
 }
 
+// isolated is the config directory used by Isolate, or "" for the
+// user's own configuration
+var (
+	isolated   string
+	isolateMux sync.Mutex
+)
+
+// Isolate keeps the configuration away from the user's: dir replaces the
+// config directory, and project files and environment variables are
+// ignored. Script runs use it so they neither depend on nor change the
+// machine's configuration.
+func Isolate(dir string) {
+	isolateMux.Lock()
+	defer isolateMux.Unlock()
+
+	isolated = dir
+}
+
+// isolatedDir returns the directory set by Isolate, if any
+func isolatedDir() (string, bool) {
+	isolateMux.Lock()
+	defer isolateMux.Unlock()
+
+	return isolated, isolated != ""
+}
+
 // GetConfigFilePath returns the path to the config file
 func GetConfigFilePath() (string, error) {
 	configDir, err := GetConfigDir()
@@ -55,9 +139,16 @@ func GetConfigFilePath() (string, error) {
 
 // Load loads the configuration from disk
 func Load() (*Data, error) {
+	config, _, err := loadGlobal()
+	return config, err
+}
+
+// loadGlobal loads the global config file together with the settings it
+// sets
+func loadGlobal() (*Data, fieldSet, error) {
 	configPath, err := GetConfigFilePath()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Check if file exists
@@ -65,23 +156,15 @@ func Load() (*Data, error) {
 		// Return default config if file doesn't exist
 		return &Data{
 			Model: DefaultModel(),
-		}, nil
-	}
-
-	// Read and parse config file
-	file, err := os.Open(configPath)
-	if err != nil {
-		return nil, err
+		}, fieldSet{"model": nil}, nil
 	}
-	defer file.Close()
 
-	var config Data
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&config); err != nil {
-		return nil, err
-	}
+	// Tighten permissions before reading a file that may hold secrets
+	checkPermissions(filepath.Dir(configPath))
+	checkPermissions(configPath)
 
-	return &config, nil
+	// Read, migrate and parse config file
+	return readConfigFile(configPath, true)
 }
 
 // Save saves the configuration to disk
@@ -91,28 +174,63 @@ func Save(config *Data) error {
 		return err
 	}
 
-	// Create config directory if it doesn't exist
-	if err := os.MkdirAll(configDir, 0755); err != nil {
+	// Create config directory if it doesn't exist, readable only by the user
+	if err := os.MkdirAll(configDir, 0700); err != nil {
 		return err
 	}
+	checkPermissions(configDir)
 
 	configPath, err := GetConfigFilePath()
 	if err != nil {
 		return err
 	}
 
-	// Write config file
-	file, err := os.Create(configPath)
+	// Keep API keys out of the plaintext file when secrets are encrypted
+	if SecretsUnlocked() {
+		plain, err := moveKeysToSecrets(config)
+		if err != nil {
+			return err
+		}
+		config = plain
+	}
+
+	// Always write the current schema version
+	versioned := *config
+	versioned.Version = CurrentVersion
+
+	data, err := json.MarshalIndent(&versioned, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(configPath, append(data, '\n'))
+}
+
+// WriteFileAtomic writes data to a temporary file with 0600 permissions
+// and renames it over path, so readers never see a partial file
+func WriteFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath)
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(config); err != nil {
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
 		return err
 	}
 
-	return nil
-}
\ No newline at end of file
+	return os.Rename(tmpPath, path)
+}
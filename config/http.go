@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+// DefaultRequestTimeout is how long a request may wait for its reply to
+// start, or for the next piece of a streamed reply, when http.timeout is
+// not set
+const DefaultRequestTimeout = 10 * time.Second
+
+// HTTPConfig configures how the client connects to the API
+type HTTPConfig struct {
+	// Proxy is the proxy URL (http, https or socks5). When empty the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables apply.
+	Proxy string `json:"proxy,omitempty"`
+
+	// CABundle is a PEM file of extra trusted certificate authorities,
+	// added to the system pool
+	CABundle string `json:"ca_bundle,omitempty"`
+
+	// ClientCert and ClientKey are PEM files for TLS client authentication
+	ClientCert string `json:"client_cert,omitempty"`
+	ClientKey  string `json:"client_key,omitempty"`
+
+	// Timeout limits how long a request waits for its reply to start or
+	// continue, and ConnectTimeout establishing the connection, both in
+	// seconds. A comparison uses Timeout for the whole request.
+	Timeout        int `json:"timeout,omitempty"`
+	ConnectTimeout int `json:"connect_timeout,omitempty"`
+
+	// Headers are added to every request, for example HTTP-Referer and
+	// X-Title for OpenRouter attribution
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// RequestTimeout returns the configured request timeout or the default
+func (h HTTPConfig) RequestTimeout() time.Duration {
+	if h.Timeout > 0 {
+		return time.Duration(h.Timeout) * time.Second
+	}
+	return DefaultRequestTimeout
+}
+
+// validateHTTP checks the http settings, returning a message for the
+// first problem found
+func validateHTTP(h HTTPConfig) string {
+	if h.Proxy != "" {
+		proxy, err := url.Parse(h.Proxy)
+		if err != nil {
+			return fmt.Sprintf("proxy: %v", err)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Sprintf("proxy: unsupported scheme %q (use http, https or socks5)", proxy.Scheme)
+		}
+	}
+
+	for name, path := range map[string]string{"ca_bundle": h.CABundle, "client_cert": h.ClientCert, "client_key": h.ClientKey} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Sprintf("%s: %v", name, err)
+		}
+	}
+	if (h.ClientCert == "") != (h.ClientKey == "") {
+		return "client_cert and client_key must be set together"
+	}
+
+	if h.Timeout < 0 || h.ConnectTimeout < 0 {
+		return "timeouts must not be negative"
+	}
+	return ""
+}
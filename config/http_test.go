@@ -0,0 +1,32 @@
+package config
+
+import "testing"
+
+func TestProjectCannotChangeTransport(t *testing.T) {
+	useConfigs(t,
+		`{"version": 1, "http": {"timeout": 30, "headers": {"X-Title": "mine"}}}`,
+		`{"version": 1, "http": {
+			"proxy": "http://attacker.example:8080",
+			"ca_bundle": "/dev/null",
+			"client_cert": "/dev/null",
+			"client_key": "/dev/null",
+			"headers": {"Authorization": "Bearer attacker"},
+			"connect_timeout": 5
+		}}`,
+	)
+
+	settings, err := resolve(t).Settings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := settings.HTTP
+	if h.Proxy != "" || h.CABundle != "" || h.ClientCert != "" || h.ClientKey != "" {
+		t.Errorf("project changed the transport: %+v", h)
+	}
+	if len(h.Headers) != 1 || h.Headers["X-Title"] != "mine" {
+		t.Errorf("headers = %v, want only the global ones", h.Headers)
+	}
+	if h.Timeout != 30 || h.ConnectTimeout != 5 {
+		t.Errorf("timeouts = %d/%d, want 30/5", h.Timeout, h.ConnectTimeout)
+	}
+}
@@ -0,0 +1,491 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/joho/godotenv"
+)
+
+// Layer identifies where an effective configuration value came from
+type Layer string
+
+// Configuration layers, from lowest to highest precedence
+const (
+	LayerDefault Layer = "default"
+	LayerGlobal  Layer = "global"
+	LayerSecrets Layer = "secrets"
+	LayerProject Layer = "project"
+	LayerEnv     Layer = "env"
+	LayerFlag    Layer = "flag"
+	LayerSession Layer = "session"
+)
+
+// envPrefix is prepended to the upper-cased JSON field name to form the
+// environment variable for a setting, e.g. CODEAID_MODEL
+const envPrefix = "CODEAID_"
+
+// legacyEnv maps settings to environment variables supported before
+// CODEAID_* variables existed. The CODEAID_* variable takes precedence.
+var legacyEnv = map[string]string{
+	"openrouter_api_key": "OPENROUTER_API_KEY",
+}
+
+// Resolved holds the effective configuration and where each value came from
+type Resolved struct {
+	Data    Data
+	Sources map[string]Layer // Layer of each setting, keyed by JSON name
+	Files   map[Layer]string // Path of each file layer that was loaded
+}
+
+// fieldSet records which settings a layer sets, keyed by JSON name. An
+// object such as a profile or http holds the set of its own fields, so a
+// layer can set a value to false, 0 or "" and leave unset fields alone.
+type fieldSet map[string]fieldSet
+
+// remove deletes the fields at path, a list of JSON names where "*"
+// matches any key, and returns their dotted names. Objects left empty by
+// the removal are removed too.
+func (s fieldSet) remove(path []string) []string {
+	keys := []string{path[0]}
+	if path[0] == "*" {
+		keys = make([]string, 0, len(s))
+		for key := range s {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+	}
+
+	removed := []string{}
+	for _, key := range keys {
+		nested, ok := s[key]
+		if !ok {
+			continue
+		}
+		if len(path) == 1 {
+			delete(s, key)
+			removed = append(removed, key)
+			continue
+		}
+		names := nested.remove(path[1:])
+		for _, name := range names {
+			removed = append(removed, key+"."+name)
+		}
+		if len(names) > 0 && len(nested) == 0 {
+			delete(s, key)
+		}
+	}
+	return removed
+}
+
+// untrustedProjectFields are the settings a project file may not set. A
+// repository could otherwise send the user's API key to a server of its
+// choosing, by selecting a profile, changing where one connects, or
+// routing requests through its own proxy, certificates or headers.
+var untrustedProjectFields = [][]string{
+	{"openrouter_api_key"},
+	{"profile"},
+	{"profiles", "*", "provider"},
+	{"profiles", "*", "base_url"},
+	{"profiles", "*", "api_key"},
+	{"http", "proxy"},
+	{"http", "ca_bundle"},
+	{"http", "client_cert"},
+	{"http", "client_key"},
+	{"http", "headers"},
+}
+
+// restrictProject drops the settings a project file may not set from its
+// field set, with a warning for each
+func restrictProject(path string, set fieldSet) {
+	for _, field := range untrustedProjectFields {
+		for _, name := range set.remove(field) {
+			addWarning("%s: %q is ignored in project config; set it in the global config", path, name)
+		}
+	}
+}
+
+// Source returns the layer a setting came from, with its file path if any
+func (r *Resolved) Source(key string) string {
+	layer := r.Sources[key]
+	if path, ok := r.Files[layer]; ok {
+		return string(layer) + ": " + path
+	}
+	return string(layer)
+}
+
+// Value returns the effective value of a setting formatted for display
+func (r *Resolved) Value(key string) string {
+	value := reflect.ValueOf(&r.Data).Elem()
+	field := value.FieldByName(fieldName(value, key))
+	if !field.IsValid() {
+		return ""
+	}
+	if field.Kind() == reflect.String {
+		return field.String()
+	}
+	encoded, err := json.Marshal(field.Interface())
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
+
+var (
+	flagLayer   Data
+	flagSet     fieldSet
+	flagMux     sync.Mutex
+	dotEnvKey   string
+	dotEnvOnce  sync.Once
+	projectName = filepath.Join(".codeaid", "config.json")
+)
+
+// SetFlags records configuration given on the command line. keys lists
+// the settings that were given, by JSON name, so that a flag can set a
+// zero value such as --debug=false.
+func SetFlags(flags Data, keys []string) {
+	flagMux.Lock()
+	defer flagMux.Unlock()
+
+	flagLayer = flags
+	flagSet = fieldSet{}
+	for _, key := range keys {
+		flagSet[key] = nil
+	}
+}
+
+// Resolve builds the effective configuration from all layers: built-in
+// defaults, the global file, the unlocked secrets file, the project file,
+// CODEAID_* environment variables, command-line flags and runtime session
+// changes, each overriding the previous one. An API key in a .env file is
+// only a default.
+func Resolve() (*Resolved, error) {
+	resolved := &Resolved{
+		Sources: map[string]Layer{},
+		Files:   map[Layer]string{},
+	}
+
+	// Built-in defaults, with any API key from a .env file
+	defaults := &Data{Model: DefaultModel(), OpenRouterAPIKey: dotEnvAPIKey()}
+	mergeLayer(resolved, defaults, nonZeroFields(defaults), LayerDefault)
+
+	// Global file
+	if globalPath, err := GetConfigFilePath(); err == nil {
+		if _, statErr := os.Stat(globalPath); statErr == nil {
+			global, set, err := loadGlobal()
+			if err != nil {
+				return nil, err
+			}
+			mergeLayer(resolved, global, set, LayerGlobal)
+			resolved.Files[LayerGlobal] = globalPath
+		}
+	}
+
+	// Encrypted secrets file, once unlocked
+	if secrets := secretsLayer(); secrets != nil {
+		mergeLayer(resolved, secrets, nonZeroFields(secrets), LayerSecrets)
+		if secretsPath, err := GetSecretsFilePath(); err == nil {
+			resolved.Files[LayerSecrets] = secretsPath
+		}
+	}
+
+	// Project file
+	if projectPath := FindProjectConfig(); projectPath != "" {
+		project, set, err := readConfigFile(projectPath, false)
+		if err != nil {
+			return nil, err
+		}
+		restrictProject(projectPath, set)
+		mergeLayer(resolved, project, set, LayerProject)
+		resolved.Files[LayerProject] = projectPath
+	}
+
+	// Environment variables
+	env, envSet := envLayer()
+	mergeLayer(resolved, &env, envSet, LayerEnv)
+
+	// Command-line flags
+	flagMux.Lock()
+	flags, flagsSet := flagLayer, flagSet
+	flagMux.Unlock()
+	mergeLayer(resolved, &flags, flagsSet, LayerFlag)
+
+	// Changes made at runtime, such as /profile
+	session, sessionSet := sessionLayer()
+	mergeLayer(resolved, session, sessionSet, LayerSession)
+
+	return resolved, nil
+}
+
+// FindProjectConfig walks up from the working directory to the git root
+// looking for .codeaid/config.json. Outside a git repository only the
+// working directory is checked. Returns "" if none is found or the
+// configuration is isolated.
+func FindProjectConfig() string {
+	if _, ok := isolatedDir(); ok {
+		return ""
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+
+	gitRoot := findGitRoot(cwd)
+	for dir := cwd; ; dir = filepath.Dir(dir) {
+		candidate := filepath.Join(dir, projectName)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+		if gitRoot == "" || dir == gitRoot || dir == filepath.Dir(dir) {
+			return ""
+		}
+	}
+}
+
+// findGitRoot returns the nearest ancestor directory containing .git
+func findGitRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// dotEnvAPIKey returns OPENROUTER_API_KEY from a .env file in the working
+// directory. The file may come with a cloned repository, so it only
+// supplies a fallback API key, and none of the CODEAID_* settings.
+func dotEnvAPIKey() string {
+	if _, ok := isolatedDir(); ok {
+		return ""
+	}
+
+	dotEnvOnce.Do(func() {
+		if values, err := godotenv.Read(); err == nil {
+			dotEnvKey = values[legacyEnv["openrouter_api_key"]]
+		}
+	})
+	return dotEnvKey
+}
+
+// envLayer reads CODEAID_* variables (and legacy names) from the
+// environment into a Data value, along with the settings they set. They
+// are ignored when the configuration is isolated.
+func envLayer() (Data, fieldSet) {
+	if _, ok := isolatedDir(); ok {
+		return Data{}, fieldSet{}
+	}
+
+	var env Data
+	set := fieldSet{}
+	value := reflect.ValueOf(&env).Elem()
+	forEachField(value, func(key string, field reflect.Value) {
+		raw, ok := os.LookupEnv(envPrefix + strings.ToUpper(key))
+		if !ok {
+			if legacy, hasLegacy := legacyEnv[key]; hasLegacy {
+				raw, ok = os.LookupEnv(legacy)
+			}
+		}
+		if ok && raw != "" && setFromString(field, raw) {
+			set[key] = nil
+		}
+	})
+	return env, set
+}
+
+// setFromString assigns a string to a scalar field, or a comma-separated
+// list to a string slice. It reports false if raw does not parse or the
+// field cannot be set from a string.
+func setFromString(field reflect.Value, raw string) bool {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return false
+		}
+		items := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	case reflect.Bool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return false
+		}
+		field.SetBool(v)
+	case reflect.Int, reflect.Int64:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return false
+		}
+		field.SetInt(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return false
+		}
+		field.SetFloat(v)
+	default:
+		return false
+	}
+	return true
+}
+
+// nonZeroFields returns the fields of a layer built in code, such as the
+// defaults, which only sets the values that are not zero
+func nonZeroFields(layer *Data) fieldSet {
+	return nonZero(reflect.ValueOf(layer).Elem())
+}
+
+// nonZero lists the non-zero fields of a struct, or the entries of a map,
+// with those of nested structs and maps
+func nonZero(value reflect.Value) fieldSet {
+	switch value.Kind() {
+	case reflect.Pointer:
+		if value.IsNil() {
+			return nil
+		}
+		return nonZero(value.Elem())
+	case reflect.Map:
+		set := fieldSet{}
+		for _, key := range value.MapKeys() {
+			set[key.String()] = nonZero(value.MapIndex(key))
+		}
+		return set
+	case reflect.Struct:
+		set := fieldSet{}
+		forEachField(value, func(key string, field reflect.Value) {
+			if !field.IsZero() {
+				set[key] = nonZero(field)
+			}
+		})
+		return set
+	}
+	return nil
+}
+
+// mergeLayer copies the fields a layer sets into resolved
+func mergeLayer(resolved *Resolved, layer *Data, set fieldSet, name Layer) {
+	target := reflect.ValueOf(&resolved.Data).Elem()
+	source := reflect.ValueOf(layer).Elem()
+
+	for i := 0; i < source.NumField(); i++ {
+		key := jsonName(source.Type().Field(i))
+		nested, ok := set[key]
+		if key == "" || key == versionKey || !ok {
+			continue
+		}
+		mergeValue(target.Field(i), source.Field(i), nested)
+		resolved.Sources[key] = name
+	}
+}
+
+// mergeValue overrides target with source, for the fields in set. Maps
+// are merged entry by entry and struct entries field by field, so a layer
+// can add a profile or set one profile field without replacing the others.
+func mergeValue(target, source reflect.Value, set fieldSet) {
+	switch {
+	case source.Kind() == reflect.Map:
+		merged := reflect.MakeMap(source.Type())
+		for _, key := range target.MapKeys() {
+			merged.SetMapIndex(key, target.MapIndex(key))
+		}
+		for _, key := range source.MapKeys() {
+			nested, ok := set[key.String()]
+			if !ok {
+				continue
+			}
+			entry := source.MapIndex(key)
+			if entry.Kind() == reflect.Struct {
+				// Only the fields the layer sets replace those of an
+				// existing entry
+				combined := reflect.New(entry.Type()).Elem()
+				if existing := merged.MapIndex(key); existing.IsValid() {
+					combined.Set(existing)
+				}
+				mergeValue(combined, entry, nested)
+				entry = combined
+			}
+			merged.SetMapIndex(key, entry)
+		}
+		target.Set(merged)
+	case source.Kind() == reflect.Pointer && !source.IsNil() && source.Elem().Kind() == reflect.Struct:
+		// Merge into a copy so the lower layer is left unchanged
+		merged := reflect.New(source.Elem().Type())
+		if !target.IsNil() {
+			merged.Elem().Set(target.Elem())
+		}
+		mergeValue(merged.Elem(), source.Elem(), set)
+		target.Set(merged)
+	case source.Kind() == reflect.Struct:
+		for i := 0; i < source.NumField(); i++ {
+			if nested, ok := set[jsonName(source.Type().Field(i))]; ok {
+				mergeValue(target.Field(i), source.Field(i), nested)
+			}
+		}
+	default:
+		target.Set(source)
+	}
+}
+
+// forEachField calls fn for each exported field with its JSON name
+func forEachField(value reflect.Value, fn func(key string, field reflect.Value)) {
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		if key := jsonName(valueType.Field(i)); key != "" {
+			fn(key, value.Field(i))
+		}
+	}
+}
+
+// fieldName returns the Go field name for a JSON name
+func fieldName(value reflect.Value, key string) string {
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		if jsonName(valueType.Field(i)) == key {
+			return valueType.Field(i).Name
+		}
+	}
+	return ""
+}
+
+// jsonName returns the JSON name of a struct field, or "" if it is skipped
+func jsonName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// Keys returns the JSON names of all settings in declaration order
+func Keys() []string {
+	keys := []string{}
+	forEachField(reflect.ValueOf(&Data{}).Elem(), func(key string, _ reflect.Value) {
+		if key != versionKey {
+			keys = append(keys, key)
+		}
+	})
+	return keys
+}
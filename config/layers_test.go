@@ -0,0 +1,151 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// useConfigs points the config directory at a temporary home with the
+// given global config and runs the test from a repository holding the
+// given project config. Empty contents leave a file out.
+func useConfigs(t *testing.T, global, project string) {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if global != "" {
+		writeTestFile(t, filepath.Join(home, ".config", "codeaid", "config.json"), global)
+	}
+
+	repo := t.TempDir()
+	if err := os.Mkdir(filepath.Join(repo, ".git"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if project != "" {
+		writeTestFile(t, filepath.Join(repo, ".codeaid", "config.json"), project)
+	}
+	t.Chdir(repo)
+}
+
+// writeTestFile writes a file, creating its directory
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// resolve resolves the configuration, failing the test on error
+func resolve(t *testing.T) *Resolved {
+	t.Helper()
+	resolved, err := Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resolved
+}
+
+func TestProjectSetsZeroValues(t *testing.T) {
+	useConfigs(t,
+		`{"version": 1, "debug": true, "profile": "p", "profiles": {"p": {"model": "m", "temperature": 1.5, "max_tokens": 500}}}`,
+		`{"version": 1, "debug": false, "profiles": {"p": {"temperature": 0}}}`,
+	)
+
+	resolved := resolve(t)
+	if resolved.Data.Debug {
+		t.Error("debug: project false did not override global true")
+	}
+	if got := resolved.Sources["debug"]; got != LayerProject {
+		t.Errorf("debug source = %s, want project", got)
+	}
+
+	settings, err := resolved.Settings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Temperature != 0 {
+		t.Errorf("temperature = %v, want 0", settings.Temperature)
+	}
+	if settings.MaxTokens != 500 || settings.Model != "m" {
+		t.Errorf("unset profile fields changed: max_tokens %d, model %q", settings.MaxTokens, settings.Model)
+	}
+}
+
+func TestEnvSetsFalse(t *testing.T) {
+	useConfigs(t, `{"version": 1, "debug": true}`, "")
+	t.Setenv("CODEAID_DEBUG", "false")
+
+	if resolve(t).Data.Debug {
+		t.Error("CODEAID_DEBUG=false did not override the global file")
+	}
+}
+
+func TestFlagsOnlyOverrideWhenGiven(t *testing.T) {
+	useConfigs(t, `{"version": 1, "debug": true, "model": "global-model"}`, "")
+	t.Cleanup(func() { SetFlags(Data{}, nil) })
+
+	SetFlags(Data{Model: ""}, nil)
+	if got := resolve(t).Data.Model; got != "global-model" {
+		t.Errorf("model = %q, want the global model when no flag is given", got)
+	}
+
+	SetFlags(Data{Debug: false}, []string{"debug"})
+	if resolve(t).Data.Debug {
+		t.Error("--debug=false did not override the global file")
+	}
+}
+
+func TestProjectWarningsAreReportedOnce(t *testing.T) {
+	useConfigs(t, `{"version": 1}`, `{"version": 1, "profile": "x"}`)
+	TakeWarnings()
+
+	for i := 0; i < 5; i++ {
+		resolve(t)
+	}
+	if warnings := TakeWarnings(); len(warnings) != 1 {
+		t.Errorf("got warnings %q after 5 resolves, want the ignored profile once", warnings)
+	}
+}
+
+func TestInvalidFieldsAreIgnored(t *testing.T) {
+	useConfigs(t, `{"version": 1, "edit_mode": "vi"}`, `{"version": 1, "edit_mode": "nano", "debug": true}`)
+	TakeWarnings()
+
+	resolved := resolve(t)
+	if resolved.Data.EditMode != "vi" || !resolved.Data.Debug {
+		t.Errorf("got edit mode %q and debug %v, want the global edit mode and the valid project field", resolved.Data.EditMode, resolved.Data.Debug)
+	}
+	resolve(t)
+	if warnings := TakeWarnings(); len(warnings) != 1 {
+		t.Errorf("got warnings %q, want the invalid edit mode once", warnings)
+	}
+}
+
+func TestDotEnvOnlySuppliesAPIKey(t *testing.T) {
+	useConfigs(t, "", "")
+	t.Setenv("OPENROUTER_API_KEY", "")
+	writeTestFile(t, ".env", "OPENROUTER_API_KEY=sk-dotenv\nCODEAID_MODEL=attacker/model\nCODEAID_DEBUG=true\n")
+	dotEnvOnce = sync.Once{}
+	t.Cleanup(func() { dotEnvOnce, dotEnvKey = sync.Once{}, "" })
+
+	resolved := resolve(t)
+	if resolved.Data.OpenRouterAPIKey != "sk-dotenv" {
+		t.Errorf("API key = %q, want the one from .env", resolved.Data.OpenRouterAPIKey)
+	}
+	if resolved.Data.Model != DefaultModel() || resolved.Data.Debug {
+		t.Errorf("got model %q and debug %v, want .env to set neither", resolved.Data.Model, resolved.Data.Debug)
+	}
+	if _, set := os.LookupEnv("CODEAID_MODEL"); set {
+		t.Error(".env was loaded into the environment")
+	}
+
+	writeTestFile(t, filepath.Join(os.Getenv("HOME"), ".config", "codeaid", "config.json"), `{"version": 1, "openrouter_api_key": "sk-global"}`)
+	if got := resolve(t).Data.OpenRouterAPIKey; got != "sk-global" {
+		t.Errorf("API key = %q, want the global key over .env", got)
+	}
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// Permissions for files and directories that may contain secrets
+const (
+	privateFileMode os.FileMode = 0600
+	privateDirMode  os.FileMode = 0700
+)
+
+var (
+	warnings    []string
+	warned      = map[string]bool{}
+	warningsMux sync.Mutex
+)
+
+// TakeWarnings returns and clears the warnings collected while loading
+// or saving configuration
+func TakeWarnings() []string {
+	warningsMux.Lock()
+	defer warningsMux.Unlock()
+
+	taken := warnings
+	warnings = nil
+	return taken
+}
+
+// addWarning records a warning for the user. The configuration is
+// resolved again on every status refresh, so each warning is only
+// recorded the first time.
+func addWarning(format string, args ...interface{}) {
+	warningsMux.Lock()
+	defer warningsMux.Unlock()
+
+	warning := fmt.Sprintf(format, args...)
+	if warned[warning] {
+		return
+	}
+	warned[warning] = true
+	warnings = append(warnings, warning)
+}
+
+// checkPermissions makes sure a config file or directory is not readable
+// by other users, fixing it and recording a warning if it was
+func checkPermissions(path string) {
+	// Unix permission bits are not meaningful on Windows
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	want := privateFileMode
+	if info.IsDir() {
+		want = privateDirMode
+	}
+
+	current := info.Mode().Perm()
+	if current&0077 == 0 {
+		return
+	}
+
+	if err := os.Chmod(path, want); err != nil {
+		addWarning("%s is accessible by other users (mode %04o) and could not be fixed: %v", path, current, err)
+		return
+	}
+	addWarning("%s was accessible by other users (mode %04o); changed to %04o", path, current, want)
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Supported providers and their default API base URLs
+const (
+	ProviderOpenRouter = "openrouter"
+	ProviderOpenAI     = "openai"
+	ProviderLocal      = "local"
+)
+
+// providerBaseURLs maps each provider to its default API base URL
+var providerBaseURLs = map[string]string{
+	ProviderOpenRouter: "https://openrouter.ai/api/v1",
+	ProviderOpenAI:     "https://api.openai.com/v1",
+	ProviderLocal:      "http://localhost:11434/v1",
+}
+
+// Default generation parameters used when the active profile does not set
+// them; there are no top-level settings for them
+const (
+	DefaultTemperature = 0.7
+	DefaultMaxTokens   = 1024
+)
+
+// Profile is a named set of connection and generation settings.
+// Temperature and MaxTokens are pointers so that 0 can be set explicitly.
+type Profile struct {
+	Provider    string   `json:"provider,omitempty"`
+	APIKey      string   `json:"api_key,omitempty"`
+	BaseURL     string   `json:"base_url,omitempty"`
+	Model       string   `json:"model,omitempty"`
+	Temperature *float32 `json:"temperature,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+}
+
+// Settings are the effective connection and generation settings after
+// applying the active profile
+type Settings struct {
+	Profile     string
+	Provider    string
+	APIKey      string
+	BaseURL     string
+	Model       string
+	Temperature float32
+	MaxTokens   int
+	HTTP        HTTPConfig
+	Debug       bool
+}
+
+var (
+	sessionProfile    string
+	sessionProfileSet bool // Whether the session chose a profile, or none
+	sessionProfileMux sync.Mutex
+)
+
+// SetSessionProfile switches the active profile for the rest of the
+// session, overriding every configuration layer. An empty name switches
+// to the top-level settings, even if a profile is set elsewhere.
+func SetSessionProfile(name string) error {
+	if name != "" {
+		resolved, err := Resolve()
+		if err != nil {
+			return err
+		}
+		if _, ok := resolved.Data.Profiles[name]; !ok {
+			return fmt.Errorf("unknown profile %q", name)
+		}
+	}
+
+	sessionProfileMux.Lock()
+	defer sessionProfileMux.Unlock()
+
+	sessionProfile = name
+	sessionProfileSet = true
+	return nil
+}
+
+// sessionLayer returns the runtime overrides made during this session and
+// the settings they set
+func sessionLayer() (*Data, fieldSet) {
+	sessionProfileMux.Lock()
+	defer sessionProfileMux.Unlock()
+
+	set := fieldSet{}
+	if sessionProfileSet {
+		set["profile"] = nil
+	}
+	return &Data{Profile: sessionProfile}, set
+}
+
+// ProfileNames returns the names of all configured profiles, sorted
+func (r *Resolved) ProfileNames() []string {
+	names := make([]string, 0, len(r.Data.Profiles))
+	for name := range r.Data.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Settings combines the top-level configuration with the active profile,
+// whose values override the top-level ones. The model is the exception:
+// one given through the environment, a flag or the session overrides the
+// profile's. A profile's api_key is kept even then, since the top-level
+// key, however it is given, is an OpenRouter key.
+func (r *Resolved) Settings() (Settings, error) {
+	settings := Settings{
+		Provider:    ProviderOpenRouter,
+		APIKey:      r.Data.OpenRouterAPIKey,
+		Model:       r.Data.Model,
+		Temperature: DefaultTemperature,
+		MaxTokens:   DefaultMaxTokens,
+	}
+	if r.Data.HTTP != nil {
+		settings.HTTP = *r.Data.HTTP
+	}
+	settings.Debug = r.Data.Debug
+
+	if name := r.Data.Profile; name != "" {
+		profile, ok := r.Data.Profiles[name]
+		if !ok {
+			return settings, fmt.Errorf("unknown profile %q", name)
+		}
+		settings.Profile = name
+
+		if profile.Provider != "" {
+			settings.Provider = profile.Provider
+		}
+		if profile.Provider != "" && profile.Provider != ProviderOpenRouter {
+			// The top-level key is an OpenRouter key
+			settings.APIKey = ""
+		}
+		if profile.APIKey != "" {
+			settings.APIKey = profile.APIKey
+		}
+		settings.BaseURL = profile.BaseURL
+		if profile.Model != "" && !r.overridden("model") {
+			settings.Model = profile.Model
+		}
+		if profile.Temperature != nil {
+			settings.Temperature = *profile.Temperature
+		}
+		if profile.MaxTokens != nil {
+			settings.MaxTokens = *profile.MaxTokens
+		}
+	}
+
+	if settings.BaseURL == "" {
+		settings.BaseURL = providerBaseURLs[settings.Provider]
+	}
+	if settings.BaseURL == "" {
+		return settings, fmt.Errorf("profile %q: unknown provider %q needs a base_url", settings.Profile, settings.Provider)
+	}
+
+	return settings, nil
+}
+
+// overridden reports whether a setting was given explicitly for this run
+// rather than read from a config file
+func (r *Resolved) overridden(key string) bool {
+	switch r.Sources[key] {
+	case LayerEnv, LayerFlag, LayerSession:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,370 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// CurrentVersion is the config file schema version written by this build
+const CurrentVersion = 1
+
+// versionKey is the JSON name of the schema version field
+const versionKey = "version"
+
+// migration upgrades raw config fields by one schema version
+type migration func(fields map[string]json.RawMessage) error
+
+// migrations[i] upgrades a file from version i to version i+1
+var migrations = []migration{
+	migrateV0ToV1,
+}
+
+// migrateV0ToV1 upgrades unversioned files, which only differ by lacking
+// the version field
+func migrateV0ToV1(fields map[string]json.RawMessage) error {
+	return nil
+}
+
+// Issue describes a problem found while validating a config file
+type Issue struct {
+	File    string
+	Field   string
+	Message string
+}
+
+// String formats the issue for display
+func (i Issue) String() string {
+	if i.Field == "" {
+		return fmt.Sprintf("%s: %s", i.File, i.Message)
+	}
+	return fmt.Sprintf("%s: %q: %s", i.File, i.Field, i.Message)
+}
+
+// readConfigFile reads, migrates and decodes a config file, returning the
+// settings it sets along with their values. Fields with problems are
+// left unset and reported as warnings. If persist is set, a migrated file
+// is written back after saving a backup of the original.
+func readConfigFile(path string, persist bool) (*Data, fieldSet, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fields, err := parseFields(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	version, err := fileVersion(fields)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if version > CurrentVersion {
+		return nil, nil, fmt.Errorf("%s: config version %d is newer than supported version %d", path, version, CurrentVersion)
+	}
+
+	// Upgrade older files, keeping a backup of the original
+	if version < CurrentVersion {
+		if err := migrate(fields, version); err != nil {
+			return nil, nil, fmt.Errorf("%s: migrating from version %d: %v", path, version, err)
+		}
+		if persist {
+			backupPath := fmt.Sprintf("%s.v%d.bak", path, version)
+			if err := WriteFileAtomic(backupPath, raw); err != nil {
+				return nil, nil, err
+			}
+			migrated, err := json.MarshalIndent(fields, "", "  ")
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := WriteFileAtomic(path, append(migrated, '\n')); err != nil {
+				return nil, nil, err
+			}
+			addWarning("%s was upgraded from version %d to %d (backup: %s)", path, version, CurrentVersion, backupPath)
+		}
+	}
+
+	// Invalid values are ignored, as if the field were not there
+	for _, issue := range validateFields(path, fields) {
+		addWarning("%s; ignored", issue)
+		delete(fields, issue.Field)
+	}
+
+	config, set := decodeFields(fields)
+	return config, set, nil
+}
+
+// parseFields splits a config file into its top-level fields
+func parseFields(raw []byte) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if fields == nil {
+		fields = map[string]json.RawMessage{}
+	}
+	return fields, nil
+}
+
+// fileVersion returns the schema version of a file, 0 if unversioned
+func fileVersion(fields map[string]json.RawMessage) (int, error) {
+	raw, ok := fields[versionKey]
+	if !ok {
+		return 0, nil
+	}
+
+	var version int
+	if err := json.Unmarshal(raw, &version); err != nil || version < 0 {
+		return 0, fmt.Errorf("%q must be a non-negative integer", versionKey)
+	}
+	return version, nil
+}
+
+// migrate applies every migration from version up to CurrentVersion
+func migrate(fields map[string]json.RawMessage, version int) error {
+	for v := version; v < CurrentVersion; v++ {
+		if err := migrations[v](fields); err != nil {
+			return err
+		}
+		fields[versionKey] = json.RawMessage(fmt.Sprint(v + 1))
+	}
+	return nil
+}
+
+// decodeFields decodes each known field on its own so that one invalid
+// value does not discard the rest of the file. It also returns the fields
+// that were decoded; null values count as unset.
+func decodeFields(fields map[string]json.RawMessage) (*Data, fieldSet) {
+	var config Data
+	set := fieldSet{}
+	value := reflect.ValueOf(&config).Elem()
+	forEachField(value, func(key string, field reflect.Value) {
+		raw, ok := fields[key]
+		if !ok || isNull(raw) {
+			return
+		}
+		target := reflect.New(field.Type())
+		if err := json.Unmarshal(raw, target.Interface()); err == nil {
+			field.Set(target.Elem())
+			set[key] = presentFields(raw)
+		}
+	})
+	return &config, set
+}
+
+// presentFields returns the non-null fields of a JSON object, with those
+// of nested objects, or nil for any other value
+func presentFields(raw json.RawMessage) fieldSet {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil
+	}
+	set := fieldSet{}
+	for key, value := range object {
+		if !isNull(value) {
+			set[key] = presentFields(value)
+		}
+	}
+	return set
+}
+
+// isNull reports whether a raw JSON value is null
+func isNull(raw json.RawMessage) bool {
+	return strings.TrimSpace(string(raw)) == "null"
+}
+
+// validateFields reports unknown fields, values of the wrong type and
+// values that are not allowed
+func validateFields(path string, fields map[string]json.RawMessage) []Issue {
+	issues := []Issue{}
+
+	known := map[string]reflect.Type{}
+	forEachField(reflect.ValueOf(&Data{}).Elem(), func(key string, field reflect.Value) {
+		known[key] = field.Type()
+	})
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fieldType, ok := known[key]
+		if !ok {
+			issues = append(issues, Issue{File: path, Field: key, Message: "unknown field"})
+			continue
+		}
+
+		target := reflect.New(fieldType)
+		if err := json.Unmarshal(fields[key], target.Interface()); err != nil {
+			issues = append(issues, Issue{
+				File:    path,
+				Field:   key,
+				Message: fmt.Sprintf("expected %s, got %s", describeType(fieldType), string(fields[key])),
+			})
+			continue
+		}
+
+		if message := validateValue(key, target.Elem()); message != "" {
+			issues = append(issues, Issue{File: path, Field: key, Message: message})
+		}
+	}
+	return issues
+}
+
+// validateValue checks the value of a single known field
+func validateValue(key string, value reflect.Value) string {
+	switch key {
+	case "model":
+		if strings.TrimSpace(value.String()) == "" {
+			return "must not be empty"
+		}
+	case "openrouter_api_key":
+		if value.String() != strings.TrimSpace(value.String()) {
+			return "must not contain leading or trailing whitespace"
+		}
+	case "edit_mode":
+		switch value.String() {
+		case "", "default", "vi", "emacs":
+		default:
+			return `must be "default", "vi" or "emacs"`
+		}
+	case "status_bar":
+		for i := 0; i < value.Len(); i++ {
+			item := value.Index(i).String()
+			if item != "none" && !slices.Contains(StatusBarItems, item) {
+				return fmt.Sprintf("unknown item %q, expected one of %s or none", item, strings.Join(StatusBarItems, ", "))
+			}
+		}
+	case "context_limits":
+		for _, pattern := range sortedKeys(value) {
+			if value.MapIndex(reflect.ValueOf(pattern)).Int() <= 0 {
+				return fmt.Sprintf("%q: must be a positive number of tokens", pattern)
+			}
+		}
+	case "prices":
+		for _, pattern := range sortedKeys(value) {
+			price := value.MapIndex(reflect.ValueOf(pattern)).Interface().(Price)
+			if price.Prompt < 0 || price.Completion < 0 {
+				return fmt.Sprintf("%q: prices must not be negative", pattern)
+			}
+		}
+	case "notifications":
+		for _, event := range sortedKeys(value) {
+			if !slices.Contains(NotificationEvents, event) {
+				return fmt.Sprintf("unknown event %q, expected one of %s", event, strings.Join(NotificationEvents, ", "))
+			}
+			methods := value.MapIndex(reflect.ValueOf(event))
+			for i := 0; i < methods.Len(); i++ {
+				if method := methods.Index(i).String(); !slices.Contains(NotificationMethods, method) {
+					return fmt.Sprintf("%q: unknown method %q, expected one of %s", event, method, strings.Join(NotificationMethods, ", "))
+				}
+			}
+		}
+	case "http":
+		if h := value.Interface().(*HTTPConfig); h != nil {
+			return validateHTTP(*h)
+		}
+	case "secret_patterns":
+		for i := 0; i < value.Len(); i++ {
+			if _, err := regexp.Compile(value.Index(i).String()); err != nil {
+				return fmt.Sprintf("invalid pattern %q: %v", value.Index(i).String(), err)
+			}
+		}
+	case "profiles":
+		for _, name := range sortedKeys(value) {
+			profile := value.MapIndex(reflect.ValueOf(name)).Interface().(Profile)
+			if _, known := providerBaseURLs[profile.Provider]; profile.Provider != "" && !known && profile.BaseURL == "" {
+				return fmt.Sprintf("profile %q: provider %q is not built in and needs a base_url", name, profile.Provider)
+			}
+			if t := profile.Temperature; t != nil && (*t < 0 || *t > 2) {
+				return fmt.Sprintf("profile %q: temperature must be between 0 and 2", name)
+			}
+			if profile.MaxTokens != nil && *profile.MaxTokens < 0 {
+				return fmt.Sprintf("profile %q: max_tokens must not be negative", name)
+			}
+		}
+	}
+	return ""
+}
+
+// sortedKeys returns the string keys of a map value in sorted order
+func sortedKeys(value reflect.Value) []string {
+	keys := make([]string, 0, value.Len())
+	for _, key := range value.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// describeType names a Go type in JSON terms for error messages
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int64, reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice:
+		return "a list"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return t.String()
+}
+
+// ValidateFiles validates the global and project config files without
+// modifying them
+func ValidateFiles() ([]Issue, error) {
+	paths := []string{}
+	if globalPath, err := GetConfigFilePath(); err == nil {
+		if _, err := os.Stat(globalPath); err == nil {
+			paths = append(paths, globalPath)
+		}
+	}
+	if projectPath := FindProjectConfig(); projectPath != "" {
+		paths = append(paths, projectPath)
+	}
+
+	issues := []Issue{}
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		fields, err := parseFields(raw)
+		if err != nil {
+			issues = append(issues, Issue{File: path, Message: err.Error()})
+			continue
+		}
+
+		version, err := fileVersion(fields)
+		if err != nil {
+			issues = append(issues, Issue{File: path, Message: err.Error()})
+			continue
+		}
+		if version > CurrentVersion {
+			issues = append(issues, Issue{File: path, Field: versionKey, Message: fmt.Sprintf("version %d is newer than supported version %d", version, CurrentVersion)})
+			continue
+		}
+		if version < CurrentVersion {
+			if err := migrate(fields, version); err != nil {
+				issues = append(issues, Issue{File: path, Message: err.Error()})
+				continue
+			}
+		}
+
+		issues = append(issues, validateFields(path, fields)...)
+	}
+	return issues, nil
+}
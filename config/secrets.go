@@ -0,0 +1,286 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// PassphraseEnv is the environment variable that unlocks the secrets file
+// without an interactive prompt
+const PassphraseEnv = "CODEAID_PASSPHRASE"
+
+// scrypt parameters for deriving the AES-256 key from the passphrase
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLen      = 16
+)
+
+// ErrWrongPassphrase is returned when the secrets file cannot be decrypted
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted secrets file")
+
+// Secrets holds the values stored in the encrypted secrets file
+type Secrets struct {
+	OpenRouterAPIKey string            `json:"openrouter_api_key"`
+	ProfileAPIKeys   map[string]string `json:"profile_api_keys,omitempty"`
+}
+
+// secretsFile is the on-disk format of the encrypted secrets file
+type secretsFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+var (
+	unlockedSecrets    *Secrets
+	unlockedPassphrase string
+	secretsMux         sync.Mutex
+)
+
+// GetSecretsFilePath returns the path to the encrypted secrets file
+func GetSecretsFilePath() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "secrets.enc"), nil
+}
+
+// SecretsExist reports whether an encrypted secrets file is present
+func SecretsExist() bool {
+	path, err := GetSecretsFilePath()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// SecretsUnlocked reports whether the secrets file has been unlocked
+func SecretsUnlocked() bool {
+	secretsMux.Lock()
+	defer secretsMux.Unlock()
+
+	return unlockedSecrets != nil
+}
+
+// UnlockSecrets decrypts the secrets file with the passphrase and keeps
+// the result in memory for the secrets configuration layer
+func UnlockSecrets(passphrase string) error {
+	path, err := GetSecretsFilePath()
+	if err != nil {
+		return err
+	}
+
+	checkPermissions(path)
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var file secretsFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return fmt.Errorf("invalid secrets file: %v", err)
+	}
+	if file.KDF != "scrypt" {
+		return fmt.Errorf("unsupported key derivation %q", file.KDF)
+	}
+	// Costs above the ones this build writes could make unlocking use
+	// unbounded memory and time
+	if file.N <= 1 || file.N > scryptN || file.R < 1 || file.R > scryptR || file.P < 1 || file.P > scryptP {
+		return fmt.Errorf("invalid secrets file: scrypt parameters N=%d r=%d p=%d are out of range", file.N, file.R, file.P)
+	}
+
+	gcm, err := newGCM(passphrase, file.Salt, file.N, file.R, file.P)
+	if err != nil {
+		return err
+	}
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return ErrWrongPassphrase
+	}
+
+	var secrets Secrets
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return fmt.Errorf("invalid secrets content: %v", err)
+	}
+
+	secretsMux.Lock()
+	defer secretsMux.Unlock()
+
+	unlockedSecrets = &secrets
+	unlockedPassphrase = passphrase
+	return nil
+}
+
+// EncryptSecrets writes secrets to the encrypted secrets file with a new
+// passphrase and unlocks them for this session
+func EncryptSecrets(secrets *Secrets, passphrase string) error {
+	if passphrase == "" {
+		return errors.New("passphrase must not be empty")
+	}
+
+	secretsMux.Lock()
+	unlockedSecrets = secrets
+	unlockedPassphrase = passphrase
+	secretsMux.Unlock()
+
+	return SaveSecrets(secrets)
+}
+
+// SaveSecrets re-encrypts secrets with the passphrase used to unlock them
+func SaveSecrets(secrets *Secrets) error {
+	secretsMux.Lock()
+	passphrase := unlockedPassphrase
+	secretsMux.Unlock()
+
+	if passphrase == "" {
+		return errors.New("secrets file is locked")
+	}
+
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(configDir, privateDirMode); err != nil {
+		return err
+	}
+
+	path, err := GetSecretsFilePath()
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	file := secretsFile{
+		Version: 1,
+		KDF:     "scrypt",
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    make([]byte, saltLen),
+	}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(passphrase, file.Salt, file.N, file.R, file.P)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Ciphertext = gcm.Seal(nil, file.Nonce, plaintext, nil)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(path, append(data, '\n')); err != nil {
+		return err
+	}
+
+	secretsMux.Lock()
+	unlockedSecrets = secrets
+	secretsMux.Unlock()
+	return nil
+}
+
+// secretsLayer returns the unlocked secrets as a configuration layer
+func secretsLayer() *Data {
+	secretsMux.Lock()
+	defer secretsMux.Unlock()
+
+	if unlockedSecrets == nil {
+		return nil
+	}
+
+	layer := &Data{OpenRouterAPIKey: unlockedSecrets.OpenRouterAPIKey}
+	for name, key := range unlockedSecrets.ProfileAPIKeys {
+		if layer.Profiles == nil {
+			layer.Profiles = map[string]Profile{}
+		}
+		layer.Profiles[name] = Profile{APIKey: key}
+	}
+	return layer
+}
+
+// moveKeysToSecrets stores every API key in config in the unlocked
+// secrets file and returns a copy of config without them
+func moveKeysToSecrets(config *Data) (*Data, error) {
+	secretsMux.Lock()
+	secrets := Secrets{ProfileAPIKeys: map[string]string{}}
+	if unlockedSecrets != nil {
+		secrets.OpenRouterAPIKey = unlockedSecrets.OpenRouterAPIKey
+		for name, key := range unlockedSecrets.ProfileAPIKeys {
+			secrets.ProfileAPIKeys[name] = key
+		}
+	}
+	secretsMux.Unlock()
+
+	plain := *config
+	changed := false
+	if plain.OpenRouterAPIKey != "" {
+		secrets.OpenRouterAPIKey = plain.OpenRouterAPIKey
+		plain.OpenRouterAPIKey = ""
+		changed = true
+	}
+
+	if len(config.Profiles) > 0 {
+		plain.Profiles = make(map[string]Profile, len(config.Profiles))
+		for name, profile := range config.Profiles {
+			if profile.APIKey != "" {
+				secrets.ProfileAPIKeys[name] = profile.APIKey
+				profile.APIKey = ""
+				changed = true
+			}
+			plain.Profiles[name] = profile
+		}
+	}
+
+	if changed {
+		if err := SaveSecrets(&secrets); err != nil {
+			return nil, err
+		}
+	}
+	return &plain, nil
+}
+
+// newGCM derives an AES-256-GCM cipher from the passphrase with scrypt
+func newGCM(passphrase string, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, n, r, p, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestUnlockRejectsExpensiveScryptParameters(t *testing.T) {
+	useConfigs(t, "", "")
+	t.Cleanup(func() { unlockedSecrets, unlockedPassphrase = nil, "" })
+
+	if err := EncryptSecrets(&Secrets{OpenRouterAPIKey: "sk-test"}, "pass"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnlockSecrets("pass"); err != nil {
+		t.Fatalf("unlocking a file written by this build: %v", err)
+	}
+
+	path, err := GetSecretsFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var original secretsFile
+	if err := json.Unmarshal(raw, &original); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, change := range map[string]func(*secretsFile){
+		"n": func(f *secretsFile) { f.N = 1 << 30 },
+		"r": func(f *secretsFile) { f.R = 1 << 20 },
+		"p": func(f *secretsFile) { f.P = 1 << 20 },
+	} {
+		file := original
+		change(&file)
+		data, err := json.Marshal(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := UnlockSecrets("pass"); err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("%s: got %v, want an out of range error", name, err)
+		}
+	}
+}
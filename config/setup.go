@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"strings"
+
+	"golang.org/x/term"
 )
 
 // RunFirstTimeSetup checks if this is a first-time run and prompts for configuration
@@ -21,6 +23,11 @@ func RunFirstTimeSetup(forceSetup bool) error {
 		if _, err := os.Stat(configPath); err == nil {
 			return nil
 		}
+
+		// An API key from a project file, the environment or flags is enough
+		if resolved, err := Resolve(); err == nil && resolved.Data.OpenRouterAPIKey != "" {
+			return nil
+		}
 	}
 
 	// Get existing config to show current values
@@ -107,4 +114,97 @@ func readInput() string {
 	reader := bufio.NewReader(os.Stdin)
 	input, _ := reader.ReadString('\n')
 	return strings.TrimSpace(input)
-}
\ No newline at end of file
+}
+
+// UnlockSecretsAtStartup unlocks the encrypted secrets file, if there is
+// one, using CODEAID_PASSPHRASE or an interactive passphrase prompt
+func UnlockSecretsAtStartup() error {
+	if !SecretsExist() {
+		return nil
+	}
+
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return UnlockSecrets(passphrase)
+	}
+
+	// Give the user a few attempts at typing the passphrase
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var passphrase string
+		passphrase, err = readPassphrase("Passphrase for encrypted secrets: ")
+		if err != nil {
+			return err
+		}
+		if err = UnlockSecrets(passphrase); err != ErrWrongPassphrase {
+			return err
+		}
+		fmt.Println("Wrong passphrase, try again.")
+	}
+	return err
+}
+
+// RunEncryptSecrets moves the API key into the encrypted secrets file,
+// protected by a new passphrase, and removes it from config.json
+func RunEncryptSecrets() error {
+	cfg, err := Load()
+	if err != nil {
+		return err
+	}
+
+	// Start from the key currently in effect, or ask for one
+	apiKey := cfg.OpenRouterAPIKey
+	if apiKey == "" {
+		if resolved, err := Resolve(); err == nil {
+			apiKey = resolved.Data.OpenRouterAPIKey
+		}
+	}
+	if apiKey == "" {
+		fmt.Print("OpenRouter API Key: ")
+		apiKey = readInput()
+	}
+	if apiKey == "" {
+		return fmt.Errorf("no API key to encrypt")
+	}
+
+	passphrase, err := readPassphrase("New passphrase: ")
+	if err != nil {
+		return err
+	}
+	confirm, err := readPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return err
+	}
+	if passphrase != confirm {
+		return fmt.Errorf("passphrases do not match")
+	}
+
+	if err := EncryptSecrets(&Secrets{OpenRouterAPIKey: apiKey}, passphrase); err != nil {
+		return err
+	}
+
+	// Remove the plaintext key from the config file
+	cfg.OpenRouterAPIKey = ""
+	if err := Save(cfg); err != nil {
+		return err
+	}
+
+	secretsPath, _ := GetSecretsFilePath()
+	fmt.Printf("API key encrypted to %s\n", secretsPath)
+	fmt.Printf("Set %s or enter the passphrase at startup to unlock it.\n", PassphraseEnv)
+	return nil
+}
+
+// readPassphrase prompts for a passphrase without echoing it
+func readPassphrase(prompt string) (string, error) {
+	fmt.Print(prompt)
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return readInput(), nil
+	}
+
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	return string(passphrase), nil
+}
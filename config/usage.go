@@ -0,0 +1,82 @@
+package config
+
+import "strings"
+
+// Price is the cost of a model in US dollars per million tokens
+type Price struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// Cost returns the cost in US dollars of a request's token usage
+func (p Price) Cost(promptTokens, completionTokens int) float64 {
+	return (float64(promptTokens)*p.Prompt + float64(completionTokens)*p.Completion) / 1e6
+}
+
+// contextLimits are the context windows of known models, matched by the
+// longest part of the model identifier
+var contextLimits = map[string]int{
+	"mistral-small-3.1": 128000,
+	"claude-3":          200000,
+	"llama-3-8b":        8192,
+	"llama-3-70b":       8192,
+	"llama-3.1":         131072,
+	"gpt-4o":            128000,
+	"gpt-4-turbo":       128000,
+	"gemini":            1000000,
+}
+
+// modelPrices are the OpenRouter prices of the built-in models
+var modelPrices = map[string]Price{
+	"claude-3-haiku":       {Prompt: 0.25, Completion: 1.25},
+	"claude-3-sonnet":      {Prompt: 3, Completion: 15},
+	"claude-3-opus":        {Prompt: 15, Completion: 75},
+	"llama-3-8b-instruct":  {Prompt: 0.03, Completion: 0.06},
+	"llama-3-70b-instruct": {Prompt: 0.3, Completion: 0.4},
+	"gpt-4o-mini":          {Prompt: 0.15, Completion: 0.6},
+	"gpt-4o":               {Prompt: 2.5, Completion: 10},
+}
+
+// ContextLimit returns the context window of a model in tokens, from the
+// context_limits setting or the built-in list, or 0 if it is unknown
+func (r *Resolved) ContextLimit(model string) int {
+	if limit, ok := longestMatch(model, r.Data.ContextLimits); ok {
+		return limit
+	}
+	limit, _ := longestMatch(model, contextLimits)
+	return limit
+}
+
+// Price returns the price of a model from the prices setting or the
+// built-in list. Free OpenRouter variants cost nothing.
+func (r *Resolved) Price(model string) (Price, bool) {
+	if price, ok := longestMatch(model, r.Data.Prices); ok {
+		return price, true
+	}
+	if strings.HasSuffix(model, ":free") {
+		return Price{}, true
+	}
+	return longestMatch(model, modelPrices)
+}
+
+// NotifyMethods returns how to notify about an event, from the
+// notifications setting or the defaults
+func (r *Resolved) NotifyMethods(event string) []string {
+	if methods, ok := r.Data.Notifications[event]; ok {
+		return methods
+	}
+	return defaultNotifications
+}
+
+// longestMatch returns the value whose key is the longest part of model
+func longestMatch[T any](model string, values map[string]T) (T, bool) {
+	model = strings.ToLower(model)
+	var best T
+	found := ""
+	for pattern, value := range values {
+		if pattern != "" && len(pattern) > len(found) && strings.Contains(model, strings.ToLower(pattern)) {
+			best, found = value, pattern
+		}
+	}
+	return best, found != ""
+}
@@ -0,0 +1,33 @@
+package config
+
+import "strings"
+
+// visionModelPatterns are parts of model identifiers that are known to
+// accept image input
+var visionModelPatterns = []string{
+	"claude-3",
+	"gpt-4o",
+	"gpt-4-turbo",
+	"gpt-4-vision",
+	"gemini",
+	"pixtral",
+	"mistral-small-3.1",
+	"llama-3.2-11b-vision",
+	"llama-3.2-90b-vision",
+	"llava",
+	"qwen-vl",
+	"qwen2.5-vl",
+}
+
+// SupportsVision reports whether a model accepts image attachments, based
+// on the built-in list and the vision_models setting
+func (r *Resolved) SupportsVision(model string) bool {
+	model = strings.ToLower(model)
+	patterns := append(append([]string{}, visionModelPatterns...), r.Data.VisionModels...)
+	for _, pattern := range patterns {
+		if pattern != "" && strings.Contains(model, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package conversation
+
+import (
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Attachment is an image sent with a chat turn. Path is the reference shown
+// in the chat; Data holds the base64-encoded image as it was attached, so
+// later requests resend the same content even if the file changes.
+type Attachment struct {
+	Path     string
+	MIMEType string
+	Data     string
+}
+
+// dataURL returns the attachment encoded as a data URL
+func (a Attachment) dataURL() string {
+	return "data:" + a.MIMEType + ";base64," + a.Data
+}
+
+// userMessage builds the API message for a prompt. Prompts with
+// attachments are sent as multi-part content.
+func userMessage(prompt string, attachments []Attachment) openai.ChatCompletionMessage {
+	if len(attachments) == 0 {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: prompt}
+	}
+
+	parts := []openai.ChatMessagePart{{Type: openai.ChatMessagePartTypeText, Text: prompt}}
+	for _, attachment := range attachments {
+		parts = append(parts, openai.ChatMessagePart{
+			Type: openai.ChatMessagePartTypeImageURL,
+			ImageURL: &openai.ChatMessageImageURL{
+				URL:    attachment.dataURL(),
+				Detail: openai.ImageURLDetailAuto,
+			},
+		})
+	}
+	return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, MultiContent: parts}
+}
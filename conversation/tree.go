@@ -0,0 +1,351 @@
+package conversation
+
+import (
+	"fmt"
+	"sync"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Turn is one entry in the conversation. Chat turns hold a user prompt
+// and the reply to it; see Kind for the other kinds of turn.
+type Turn struct {
+	ID       int
+	Kind     Kind
+	State    State
+	Prompt   string
+	Reply    string
+	Error    string
+	Injected []openai.ChatCompletionMessage
+
+	// Attachments are images sent along with the prompt
+	Attachments []Attachment
+
+	// Meta describes the request that produced the reply
+	Meta Metadata
+
+	parent   *Turn
+	children []*Turn
+	active   int // Index of the active child
+}
+
+// Branch describes a turn on the active path that has alternatives
+type Branch struct {
+	Index    int    // 1-based position among user prompts on the active path
+	Current  int    // 1-based position of the active alternative
+	Count    int    // Number of alternatives
+	Prompt   string // Prompt of the active alternative
+	Variants []string
+}
+
+// Tree stores the conversation as a tree of turns. It is the single source
+// of truth for both the chat view and the API requests: the view renders
+// the active path and requests are built from its completed turns.
+// Editing or retrying a turn adds a sibling, so earlier branches are
+// preserved and can be switched back to. The active path runs from the
+// root through each turn's active child.
+type Tree struct {
+	mu     sync.Mutex
+	root   *Turn
+	nextID int
+}
+
+// NewTree creates an empty conversation tree
+func NewTree() *Tree {
+	return &Tree{root: &Turn{}, nextID: 1}
+}
+
+// Clear removes every turn
+func (t *Tree) Clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.root = &Turn{}
+}
+
+// Begin appends a new pending chat turn with the given prompt and
+// attachments to the end of the active path and returns a copy of it
+func (t *Tree) Begin(prompt string, attachments []Attachment) Turn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	turn := &Turn{Kind: KindChat, State: StatePending, Prompt: prompt, Attachments: attachments}
+	return *t.addChild(t.leaf(), turn)
+}
+
+// Inject appends plugin-provided messages to the end of the active path
+func (t *Tree) Inject(msgs []openai.ChatCompletionMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.addChild(t.leaf(), &Turn{Kind: KindInjected, State: StateCompleted, Injected: msgs})
+}
+
+// AddNote appends display-only text to the end of the active path: the
+// command the user typed and/or the output it produced
+func (t *Tree) AddNote(input, output string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.addChild(t.leaf(), &Turn{Kind: KindNote, State: StateCompleted, Prompt: input, Reply: output})
+}
+
+// Leaf returns a copy of the last turn on the active path
+func (t *Tree) Leaf() (Turn, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	leaf := t.leaf()
+	return *leaf, leaf != t.root
+}
+
+// Path returns copies of the turns on the active path, oldest first
+func (t *Tree) Path() []Turn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	path := []Turn{}
+	for _, turn := range t.path() {
+		path = append(path, *turn)
+	}
+	return path
+}
+
+// Messages returns the API messages for the active path up to and
+// including the turn with the given ID. The reply of that turn is left
+// out so it can be requested.
+func (t *Tree) Messages(throughID int) []openai.ChatCompletionMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	msgs := []openai.ChatCompletionMessage{}
+	for _, turn := range t.path() {
+		switch {
+		case turn.Kind == KindInjected:
+			msgs = append(msgs, turn.Injected...)
+		case turn.Kind != KindChat:
+			// Notes are never sent
+		case turn.ID == throughID:
+			msgs = append(msgs, userMessage(turn.Prompt, turn.Attachments))
+			return msgs
+		case turn.State == StateCompleted:
+			// Only completed exchanges are sent; cancelled and failed turns
+			// stay visible but are left out
+			msgs = append(msgs,
+				userMessage(turn.Prompt, turn.Attachments),
+				openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: turn.Reply},
+			)
+		}
+	}
+	return msgs
+}
+
+// History returns the API messages for the whole active path
+func (t *Tree) History() []openai.ChatCompletionMessage {
+	return t.Messages(-1)
+}
+
+// Retry adds a sibling of the last prompted turn with the same prompt, so
+// its reply can be regenerated while the previous one stays available
+func (t *Tree) Retry() (Turn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prompted := t.prompted()
+	if len(prompted) == 0 {
+		return Turn{}, fmt.Errorf("nothing to retry")
+	}
+
+	last := prompted[len(prompted)-1]
+	retry := &Turn{Kind: KindChat, State: StatePending, Prompt: last.Prompt, Attachments: last.Attachments}
+	return *t.addChild(last.parent, retry), nil
+}
+
+// Alternative adds a completed sibling of the chat turn with the given ID
+// that has the same prompt and the given reply, e.g. an answer picked from
+// a comparison of several models, and makes it active
+func (t *Tree) Alternative(id int, reply string, meta Metadata) (Turn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	turn := t.find(t.root, id)
+	if turn == nil || turn == t.root || turn.Kind != KindChat {
+		return Turn{}, fmt.Errorf("message no longer exists")
+	}
+	alternative := &Turn{Kind: KindChat, State: StateCompleted, Prompt: turn.Prompt, Reply: reply, Attachments: turn.Attachments, Meta: meta}
+	return *t.addChild(turn.parent, alternative), nil
+}
+
+// LastPrompted returns a copy of the last chat turn on the active path
+func (t *Tree) LastPrompted() (Turn, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prompted := t.prompted()
+	if len(prompted) == 0 {
+		return Turn{}, false
+	}
+	return *prompted[len(prompted)-1], true
+}
+
+// Edit adds a sibling of the index-th prompt on the active path (1-based)
+// with a new prompt, starting a new branch from that point. Attachments of
+// the original prompt are kept.
+func (t *Tree) Edit(index int, prompt string) (Turn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	turn, err := t.promptAt(index)
+	if err != nil {
+		return Turn{}, err
+	}
+	edited := &Turn{Kind: KindChat, State: StatePending, Prompt: prompt, Attachments: turn.Attachments}
+	return *t.addChild(turn.parent, edited), nil
+}
+
+// PromptAt returns the prompt of the index-th prompted turn (1-based) on
+// the active path
+func (t *Tree) PromptAt(index int) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	turn, err := t.promptAt(index)
+	if err != nil {
+		return "", err
+	}
+	return turn.Prompt, nil
+}
+
+// PromptCount returns the number of prompted turns on the active path
+func (t *Tree) PromptCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.prompted())
+}
+
+// Branches lists the prompted turns on the active path that have
+// alternatives
+func (t *Tree) Branches() []Branch {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	branches := []Branch{}
+	for i, turn := range t.prompted() {
+		siblings := turn.parent.children
+		if len(siblings) < 2 {
+			continue
+		}
+
+		branch := Branch{
+			Index:   i + 1,
+			Current: turn.parent.active + 1,
+			Count:   len(siblings),
+			Prompt:  turn.Prompt,
+		}
+		for _, sibling := range siblings {
+			branch.Variants = append(branch.Variants, sibling.Prompt)
+		}
+		branches = append(branches, branch)
+	}
+	return branches
+}
+
+// SelectBranch makes the alternative-th sibling (1-based) of the index-th
+// prompted turn active
+func (t *Tree) SelectBranch(index, alternative int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	turn, err := t.promptAt(index)
+	if err != nil {
+		return err
+	}
+
+	siblings := turn.parent.children
+	if alternative < 1 || alternative > len(siblings) {
+		return fmt.Errorf("message %d has %d branches", index, len(siblings))
+	}
+	turn.parent.active = alternative - 1
+	return nil
+}
+
+// SwitchBranch moves to the next (delta > 0) or previous (delta < 0)
+// alternative at the deepest point of the active path that has one.
+// It reports whether anything changed.
+func (t *Tree) SwitchBranch(delta int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	path := t.path()
+	for i := len(path) - 1; i >= 0; i-- {
+		parent := path[i].parent
+		if count := len(parent.children); count > 1 {
+			parent.active = ((parent.active+delta)%count + count) % count
+			return true
+		}
+	}
+	return false
+}
+
+// addChild attaches a new turn under parent and makes it active
+func (t *Tree) addChild(parent *Turn, turn *Turn) *Turn {
+	turn.ID = t.nextID
+	t.nextID++
+	turn.parent = parent
+	parent.children = append(parent.children, turn)
+	parent.active = len(parent.children) - 1
+	return turn
+}
+
+// path returns the turns on the active path, excluding the root
+func (t *Tree) path() []*Turn {
+	path := []*Turn{}
+	for turn := t.root; len(turn.children) > 0; {
+		turn = turn.children[turn.active]
+		path = append(path, turn)
+	}
+	return path
+}
+
+// leaf returns the last turn on the active path, or the root if empty
+func (t *Tree) leaf() *Turn {
+	turn := t.root
+	for len(turn.children) > 0 {
+		turn = turn.children[turn.active]
+	}
+	return turn
+}
+
+// prompted returns the chat turns on the active path
+func (t *Tree) prompted() []*Turn {
+	prompted := []*Turn{}
+	for _, turn := range t.path() {
+		if turn.Kind == KindChat {
+			prompted = append(prompted, turn)
+		}
+	}
+	return prompted
+}
+
+// promptAt returns the index-th prompted turn (1-based) on the active path
+func (t *Tree) promptAt(index int) (*Turn, error) {
+	prompted := t.prompted()
+	if index < 1 || index > len(prompted) {
+		return nil, fmt.Errorf("no message %d (there are %d)", index, len(prompted))
+	}
+	return prompted[index-1], nil
+}
+
+// find returns the turn with the given ID below turn
+func (t *Tree) find(turn *Turn, id int) *Turn {
+	if turn.ID == id {
+		return turn
+	}
+	for _, child := range turn.children {
+		if found := t.find(child, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
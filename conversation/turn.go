@@ -0,0 +1,236 @@
+package conversation
+
+import (
+	"fmt"
+	"time"
+)
+
+// Kind distinguishes the entries stored in the conversation
+type Kind int
+
+const (
+	// KindChat is a user prompt and the model's reply
+	KindChat Kind = iota
+
+	// KindNote is display-only text such as a command and its output;
+	// it is never sent to the model
+	KindNote
+
+	// KindInjected holds messages added by plugins; they are sent to the
+	// model as they are
+	KindInjected
+)
+
+// State tracks a chat turn through its request
+type State int
+
+const (
+	// StatePending is waiting for a reply
+	StatePending State = iota
+
+	// StateCompleted has a reply and is part of the history sent to the model
+	StateCompleted
+
+	// StateCancelled was cancelled by the user; it is shown but not sent
+	StateCancelled
+
+	// StateFailed ended with an error; it is shown but not sent
+	StateFailed
+)
+
+// String returns the state name
+func (s State) String() string {
+	switch s {
+	case StatePending:
+		return "pending"
+	case StateCompleted:
+		return "completed"
+	case StateCancelled:
+		return "cancelled"
+	case StateFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// FinishReasonLength is the finish reason of a reply that was cut off at
+// the token limit
+const FinishReasonLength = "length"
+
+// Metadata describes the request that produced a reply
+type Metadata struct {
+	Model            string
+	Latency          time.Duration
+	PromptTokens     int
+	CompletionTokens int
+	FinishReason     string
+}
+
+// Truncated reports whether the reply was cut off at the token limit
+func (m Metadata) Truncated() bool {
+	return m.FinishReason == FinishReasonLength
+}
+
+// Complete stores the reply for a pending turn. It reports false, and
+// stores nothing, if the turn is no longer pending (for example because
+// it was cancelled while the request was in flight).
+func (t *Tree) Complete(id int, reply string, meta Metadata) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	turn := t.find(t.root, id)
+	if turn == nil || turn.State != StatePending {
+		return false
+	}
+	turn.State = StateCompleted
+	turn.Reply = reply
+	turn.Meta = meta
+	return true
+}
+
+// Stream appends part of a reply to a pending turn as it arrives; the
+// finished reply replaces it on Complete. It reports false if the turn is
+// no longer pending.
+func (t *Tree) Stream(id int, delta string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	turn := t.find(t.root, id)
+	if turn == nil || turn == t.root || turn.State != StatePending {
+		return false
+	}
+	turn.Reply += delta
+	return true
+}
+
+// Extend appends a continuation to the reply of a completed turn, adding
+// up latency and token counts. It reports false if the turn no longer
+// exists or is not completed.
+func (t *Tree) Extend(id int, more string, meta Metadata) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	turn := t.find(t.root, id)
+	if turn == nil || turn == t.root || turn.State != StateCompleted {
+		return false
+	}
+	turn.Reply += more
+	turn.Meta.Model = meta.Model
+	turn.Meta.Latency += meta.Latency
+	turn.Meta.PromptTokens += meta.PromptTokens
+	turn.Meta.CompletionTokens += meta.CompletionTokens
+	turn.Meta.FinishReason = meta.FinishReason
+	return true
+}
+
+// Attach sets the images sent with a pending turn, once they are loaded.
+// It reports false if the turn no longer exists or is not pending.
+func (t *Tree) Attach(id int, attachments []Attachment) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	turn := t.find(t.root, id)
+	if turn == nil || turn == t.root || turn.State != StatePending {
+		return false
+	}
+	turn.Attachments = attachments
+	return true
+}
+
+// Fail marks a pending turn as failed with an error message
+func (t *Tree) Fail(id int, message string) bool {
+	return t.finish(id, StateFailed, message)
+}
+
+// Cancel marks a pending turn as cancelled
+func (t *Tree) Cancel(id int) bool {
+	return t.finish(id, StateCancelled, "")
+}
+
+// CancelPending marks every pending turn as cancelled
+func (t *Tree) CancelPending() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.walk(t.root, func(turn *Turn) {
+		if turn.State == StatePending && turn.Kind == KindChat {
+			turn.State = StateCancelled
+		}
+	})
+}
+
+// Pending reports whether the turn with the given ID is still pending
+func (t *Tree) Pending(id int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	turn := t.find(t.root, id)
+	return turn != nil && turn.State == StatePending
+}
+
+// Turn returns a copy of the turn with the given ID
+func (t *Tree) Turn(id int) (Turn, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	turn := t.find(t.root, id)
+	if turn == nil || turn == t.root {
+		return Turn{}, false
+	}
+	return *turn, true
+}
+
+// Undo removes the last chat turn on the active path together with any
+// notes after it, and its retries: siblings with the same prompt, such as
+// those added by /retry or picked from a comparison. Siblings with an
+// edited prompt are kept, and the second result reports whether one of
+// them is now shown in its place.
+func (t *Tree) Undo() (Turn, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prompted := t.prompted()
+	if len(prompted) == 0 {
+		return Turn{}, false, fmt.Errorf("nothing to undo")
+	}
+
+	last := prompted[len(prompted)-1]
+	parent := last.parent
+	kept := []*Turn{}
+	for _, child := range parent.children {
+		if child == last || child.Kind == KindChat && child.Prompt == last.Prompt {
+			continue
+		}
+		kept = append(kept, child)
+	}
+	parent.children = kept
+	if parent.active >= len(parent.children) {
+		parent.active = len(parent.children) - 1
+	}
+	if parent.active < 0 {
+		parent.active = 0
+	}
+	return *last, len(kept) > 0, nil
+}
+
+// finish moves a pending turn to a final state without a reply
+func (t *Tree) finish(id int, state State, message string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	turn := t.find(t.root, id)
+	if turn == nil || turn.State != StatePending {
+		return false
+	}
+	turn.State = state
+	turn.Error = message
+	return true
+}
+
+// walk calls fn for every turn below turn
+func (t *Tree) walk(turn *Turn, fn func(*Turn)) {
+	for _, child := range turn.children {
+		fn(child)
+		t.walk(child, fn)
+	}
+}
@@ -0,0 +1,359 @@
+package editor
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Mode selects how keys edit the buffer
+type Mode string
+
+// Editing modes
+const (
+	ModeDefault Mode = "default"
+	ModeVi      Mode = "vi"
+	ModeEmacs   Mode = "emacs"
+)
+
+// ParseMode converts a config value to a mode; unknown values and the
+// empty string select the default mode
+func ParseMode(value string) Mode {
+	switch Mode(strings.ToLower(value)) {
+	case ModeVi:
+		return ModeVi
+	case ModeEmacs:
+		return ModeEmacs
+	}
+	return ModeDefault
+}
+
+// state is a snapshot of the text and cursor kept for undo
+type state struct {
+	text   []rune
+	cursor int
+}
+
+// maxUndo limits how many changes can be undone
+const maxUndo = 100
+
+// Buffer is the text of the input box with a cursor, a register for
+// deleted or killed text, and an undo history. The cursor is a rune index.
+// Every edit builds a new text slice, so copies of a Buffer never share
+// changes.
+type Buffer struct {
+	text      []rune
+	cursor    int
+	register  []rune
+	undo      []state
+	inserting bool // The last change was typing, which undo groups together
+
+	// Modal editing state
+	mode    Mode
+	normal  bool // Vi normal mode; otherwise insert mode
+	pending rune // Vi operator waiting for a motion, such as 'd' or 'c'
+}
+
+// String returns the text
+func (b *Buffer) String() string {
+	return string(b.text)
+}
+
+// Len returns the length of the text in runes
+func (b *Buffer) Len() int {
+	return len(b.text)
+}
+
+// Cursor returns the cursor position in runes
+func (b *Buffer) Cursor() int {
+	return b.cursor
+}
+
+// Split returns the text before the cursor, the character under it (empty
+// at the end of the text) and the text after it, for rendering
+func (b *Buffer) Split() (before, at, after string) {
+	if b.cursor >= len(b.text) {
+		return string(b.text), "", ""
+	}
+	return string(b.text[:b.cursor]), string(b.text[b.cursor]), string(b.text[b.cursor+1:])
+}
+
+// SetText replaces the text and puts the cursor at its end
+func (b *Buffer) SetText(text string) {
+	b.checkpoint()
+	b.text = []rune(text)
+	b.cursor = len(b.text)
+	b.clampCursor()
+}
+
+// Reset empties the buffer and its undo history for new input. Vi mode
+// starts again in insert mode.
+func (b *Buffer) Reset() {
+	b.text = nil
+	b.cursor = 0
+	b.undo = nil
+	b.inserting = false
+	b.normal = false
+	b.pending = 0
+}
+
+// Clear empties the text as an edit that can be undone
+func (b *Buffer) Clear() {
+	if len(b.text) == 0 {
+		return
+	}
+	b.checkpoint()
+	b.text = nil
+	b.cursor = 0
+	b.inserting = false
+}
+
+// Insert inserts text at the cursor. Consecutive inserts are undone
+// together.
+func (b *Buffer) Insert(text string) {
+	if !b.inserting {
+		b.checkpoint()
+	}
+	runes := []rune(text)
+	b.replace(b.cursor, b.cursor, runes)
+	b.cursor += len(runes)
+	b.inserting = true
+}
+
+// DeleteBack deletes the character before the cursor
+func (b *Buffer) DeleteBack() bool {
+	if b.cursor == 0 {
+		return false
+	}
+	b.checkpoint()
+	b.replace(b.cursor-1, b.cursor, nil)
+	b.cursor--
+	return true
+}
+
+// DeleteForward deletes the character at the cursor
+func (b *Buffer) DeleteForward() bool {
+	if b.cursor >= len(b.text) {
+		return false
+	}
+	b.checkpoint()
+	b.replace(b.cursor, b.cursor+1, nil)
+	return true
+}
+
+// Left moves the cursor one character left
+func (b *Buffer) Left() {
+	b.moveTo(b.cursor - 1)
+}
+
+// Right moves the cursor one character right
+func (b *Buffer) Right() {
+	b.moveTo(b.cursor + 1)
+}
+
+// Home moves the cursor to the start of the text
+func (b *Buffer) Home() {
+	b.moveTo(0)
+}
+
+// End moves the cursor to the end of the text
+func (b *Buffer) End() {
+	b.moveTo(len(b.text))
+}
+
+// LineUp moves the cursor to the previous line, keeping the column. It
+// reports false on the first line.
+func (b *Buffer) LineUp() bool {
+	start := b.lineStart(b.cursor)
+	if start == 0 {
+		return false
+	}
+	column := b.cursor - start
+	prevStart := b.lineStart(start - 1)
+	b.moveTo(min(prevStart+column, start-1))
+	return true
+}
+
+// LineDown moves the cursor to the next line, keeping the column. It
+// reports false on the last line.
+func (b *Buffer) LineDown() bool {
+	end := b.lineEnd(b.cursor)
+	if end >= len(b.text) {
+		return false
+	}
+	column := b.cursor - b.lineStart(b.cursor)
+	b.moveTo(min(end+1+column, b.lineEnd(end+1)))
+	return true
+}
+
+// Kill deletes the text from start to end into the register
+func (b *Buffer) Kill(start, end int) {
+	start, end = max(start, 0), min(end, len(b.text))
+	if start >= end {
+		return
+	}
+	b.checkpoint()
+	b.register = append([]rune{}, b.text[start:end]...)
+	b.replace(start, end, nil)
+	b.cursor = start
+}
+
+// Yank inserts the register at the cursor, leaving the cursor after it
+func (b *Buffer) Yank() {
+	if len(b.register) == 0 {
+		return
+	}
+	b.checkpoint()
+	b.replace(b.cursor, b.cursor, b.register)
+	b.cursor += len(b.register)
+}
+
+// Undo restores the text before the last change
+func (b *Buffer) Undo() bool {
+	if len(b.undo) == 0 {
+		return false
+	}
+	last := b.undo[len(b.undo)-1]
+	b.undo = b.undo[:len(b.undo)-1]
+	b.text = last.text
+	b.cursor = last.cursor
+	b.inserting = false
+	b.clampCursor()
+	return true
+}
+
+// checkpoint saves the current text for undo
+func (b *Buffer) checkpoint() {
+	b.inserting = false
+	b.undo = append(b.undo, state{text: b.text, cursor: b.cursor})
+	if len(b.undo) > maxUndo {
+		b.undo = b.undo[len(b.undo)-maxUndo:]
+	}
+}
+
+// replace swaps text[start:end] for runes in a new slice
+func (b *Buffer) replace(start, end int, runes []rune) {
+	text := make([]rune, 0, len(b.text)-(end-start)+len(runes))
+	text = append(text, b.text[:start]...)
+	text = append(text, runes...)
+	text = append(text, b.text[end:]...)
+	b.text = text
+}
+
+// moveTo moves the cursor, ending any group of inserts
+func (b *Buffer) moveTo(position int) {
+	b.cursor = position
+	b.inserting = false
+	b.clampCursor()
+}
+
+// clampCursor keeps the cursor inside the text. In vi normal mode the
+// cursor sits on a character, so it cannot go past the last one.
+func (b *Buffer) clampCursor() {
+	limit := len(b.text)
+	if b.mode == ModeVi && b.normal && limit > 0 {
+		limit--
+	}
+	b.cursor = max(min(b.cursor, limit), 0)
+}
+
+// lineStart returns the start of the line containing position
+func (b *Buffer) lineStart(position int) int {
+	for position > 0 && b.text[position-1] != '\n' {
+		position--
+	}
+	return position
+}
+
+// lineEnd returns the position of the newline ending the line containing
+// position, or the end of the text
+func (b *Buffer) lineEnd(position int) int {
+	for position < len(b.text) && b.text[position] != '\n' {
+		position++
+	}
+	return position
+}
+
+// charClass groups characters into words the way vi does: blanks, word
+// characters and other punctuation
+func charClass(r rune) int {
+	switch {
+	case unicode.IsSpace(r):
+		return 0
+	case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+		return 1
+	}
+	return 2
+}
+
+// nextWordStart returns the start of the next word after position (vi w)
+func (b *Buffer) nextWordStart(position int) int {
+	n := len(b.text)
+	if position < n {
+		class := charClass(b.text[position])
+		for position < n && class != 0 && charClass(b.text[position]) == class {
+			position++
+		}
+	}
+	for position < n && charClass(b.text[position]) == 0 {
+		position++
+	}
+	return position
+}
+
+// prevWordStart returns the start of the word before position (vi b)
+func (b *Buffer) prevWordStart(position int) int {
+	position--
+	for position > 0 && charClass(b.text[position]) == 0 {
+		position--
+	}
+	if position <= 0 {
+		return 0
+	}
+	class := charClass(b.text[position])
+	for position > 0 && charClass(b.text[position-1]) == class {
+		position--
+	}
+	return position
+}
+
+// wordEnd returns the last character of the word at or after position + 1
+// (vi e)
+func (b *Buffer) wordEnd(position int) int {
+	n := len(b.text)
+	position++
+	for position < n && charClass(b.text[position]) == 0 {
+		position++
+	}
+	if position >= n {
+		return max(n-1, 0)
+	}
+	class := charClass(b.text[position])
+	for position+1 < n && charClass(b.text[position+1]) == class {
+		position++
+	}
+	return position
+}
+
+// forwardWord returns the end of the next word (Emacs alt+f), where words
+// are letters and digits
+func (b *Buffer) forwardWord(position int) int {
+	n := len(b.text)
+	for position < n && charClass(b.text[position]) != 1 {
+		position++
+	}
+	for position < n && charClass(b.text[position]) == 1 {
+		position++
+	}
+	return position
+}
+
+// backwardWord returns the start of the previous word (Emacs alt+b)
+func (b *Buffer) backwardWord(position int) int {
+	for position > 0 && charClass(b.text[position-1]) != 1 {
+		position--
+	}
+	for position > 0 && charClass(b.text[position-1]) == 1 {
+		position--
+	}
+	return position
+}
@@ -0,0 +1,238 @@
+package editor
+
+import (
+	"codeaid/keys"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// SetMode selects the editing mode. Vi mode starts in insert mode.
+func (b *Buffer) SetMode(mode Mode) {
+	b.mode = mode
+	b.normal = false
+	b.pending = 0
+}
+
+// Mode returns the editing mode
+func (b *Buffer) Mode() Mode {
+	if b.mode == "" {
+		return ModeDefault
+	}
+	return b.mode
+}
+
+// Normal reports whether the buffer is in vi normal mode
+func (b *Buffer) Normal() bool {
+	return b.mode == ModeVi && b.normal
+}
+
+// Indicator describes the vi mode for display, or is empty in other modes
+func (b *Buffer) Indicator() string {
+	if b.mode != ModeVi {
+		return ""
+	}
+	if !b.normal {
+		return "-- INSERT --"
+	}
+	if b.pending != 0 {
+		return "-- NORMAL -- " + string(b.pending)
+	}
+	return "-- NORMAL --"
+}
+
+// HandleKey applies a key in the current editing mode. It reports whether
+// the mode used the key; keys it does not use are left to the keymap. A
+// key can also be turned into a keymap action, such as vi's k on the first
+// line recalling earlier input.
+func (b *Buffer) HandleKey(msg tea.KeyMsg) (bool, keys.Action) {
+	switch b.mode {
+	case ModeVi:
+		return b.handleVi(msg)
+	case ModeEmacs:
+		return b.handleEmacs(msg), keys.None
+	}
+	return false, keys.None
+}
+
+// handleEmacs applies the Emacs movement and kill keys
+func (b *Buffer) handleEmacs(msg tea.KeyMsg) bool {
+	switch msg.String() {
+	case "ctrl+a":
+		b.moveTo(b.lineStart(b.cursor))
+	case "ctrl+e":
+		b.moveTo(b.lineEnd(b.cursor))
+	case "ctrl+f":
+		b.Right()
+	case "ctrl+b":
+		b.Left()
+	case "alt+f":
+		b.moveTo(b.forwardWord(b.cursor))
+	case "alt+b":
+		b.moveTo(b.backwardWord(b.cursor))
+	case "ctrl+k":
+		// Kill to the end of the line, or the line break at its end
+		end := b.lineEnd(b.cursor)
+		if end == b.cursor {
+			end++
+		}
+		b.Kill(b.cursor, end)
+	case "ctrl+y":
+		b.Yank()
+	default:
+		return false
+	}
+	return true
+}
+
+// handleVi applies vi keys: Esc leaves insert mode, and in normal mode
+// letters are commands rather than text
+func (b *Buffer) handleVi(msg tea.KeyMsg) (bool, keys.Action) {
+	if !b.normal {
+		if msg.Type != tea.KeyEsc {
+			return false, keys.None
+		}
+		b.normal = true
+		b.moveTo(b.cursor - 1)
+		return true, keys.None
+	}
+
+	// Esc cancels a pending operator; otherwise it is left to the keymap,
+	// which cancels a request but keeps the input in normal mode
+	if msg.Type == tea.KeyEsc {
+		if b.pending != 0 {
+			b.pending = 0
+			return true, keys.None
+		}
+		return false, keys.None
+	}
+	// Space moves right as in vi; pasted text is dropped rather than
+	// inserted, since normal mode never inserts
+	if msg.Type == tea.KeySpace && !msg.Alt {
+		b.pending = 0
+		b.Right()
+		return true, keys.None
+	}
+	if msg.Type == tea.KeyRunes && !msg.Alt && (msg.Paste || len(msg.Runes) != 1) {
+		b.pending = 0
+		return true, keys.None
+	}
+	if msg.Type != tea.KeyRunes || msg.Alt {
+		b.pending = 0
+		return false, keys.None
+	}
+
+	key := msg.Runes[0]
+	if b.pending != 0 {
+		operator := b.pending
+		b.pending = 0
+		b.applyOperator(operator, key)
+		return true, keys.None
+	}
+
+	switch key {
+	case 'i':
+		b.insertMode()
+	case 'a':
+		b.insertMode()
+		b.Right()
+	case 'I':
+		b.insertMode()
+		b.moveTo(b.lineStart(b.cursor))
+	case 'A':
+		b.insertMode()
+		b.moveTo(b.lineEnd(b.cursor))
+	case 'h':
+		b.Left()
+	case 'l':
+		b.Right()
+	case 'j':
+		if !b.LineDown() {
+			return true, keys.HistoryNext
+		}
+	case 'k':
+		if !b.LineUp() {
+			return true, keys.HistoryPrev
+		}
+	case '0':
+		b.moveTo(b.lineStart(b.cursor))
+	case '$':
+		b.moveTo(b.lineEnd(b.cursor))
+	case 'w':
+		b.moveTo(b.nextWordStart(b.cursor))
+	case 'b':
+		b.moveTo(b.prevWordStart(b.cursor))
+	case 'e':
+		b.moveTo(b.wordEnd(b.cursor))
+	case 'x':
+		b.Kill(b.cursor, b.cursor+1)
+		b.clampCursor()
+	case 'p':
+		b.put(true)
+	case 'P':
+		b.put(false)
+	case 'u':
+		b.Undo()
+	case 'd', 'c':
+		b.pending = key
+	}
+	return true, keys.None
+}
+
+// applyOperator runs d or c with a motion: dd and cc act on the line, dw
+// deletes to the next word, cw changes to the end of the word and d$ or
+// c$ act to the end of the line
+func (b *Buffer) applyOperator(operator, motion rune) {
+	start, end := b.cursor, b.cursor
+	switch {
+	case motion == operator:
+		start, end = b.lineStart(b.cursor), b.lineEnd(b.cursor)
+		if operator == 'd' {
+			// Remove the line break too, so the line disappears
+			if end < len(b.text) {
+				end++
+			} else if start > 0 {
+				start--
+			}
+		}
+	case motion == 'w' && operator == 'c' && b.cursor < len(b.text) && charClass(b.text[b.cursor]) != 0:
+		// Like vi, cw stops at the end of the word instead of the next one
+		class := charClass(b.text[b.cursor])
+		for end < len(b.text) && charClass(b.text[end]) == class {
+			end++
+		}
+	case motion == 'w':
+		end = b.nextWordStart(b.cursor)
+	case motion == 'e':
+		end = b.wordEnd(b.cursor) + 1
+	case motion == '$':
+		end = b.lineEnd(b.cursor)
+	default:
+		return
+	}
+
+	b.Kill(start, end)
+	if operator == 'c' {
+		b.insertMode()
+		return
+	}
+	b.clampCursor()
+}
+
+// put pastes the register after or before the cursor, leaving the cursor
+// on the last pasted character
+func (b *Buffer) put(after bool) {
+	if len(b.register) == 0 {
+		return
+	}
+	if after && len(b.text) > 0 {
+		b.cursor++
+	}
+	b.Yank()
+	b.moveTo(b.cursor - 1)
+}
+
+// insertMode leaves vi normal mode
+func (b *Buffer) insertMode() {
+	b.normal = false
+	b.inserting = false
+}
@@ -0,0 +1,233 @@
+package keys
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"codeaid/config"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Action names something the user can do with a key
+type Action string
+
+// Actions that can be bound to keys
+const (
+	None          Action = ""
+	Submit        Action = "submit"
+	Newline       Action = "newline"
+	Cancel        Action = "cancel"
+	Quit          Action = "quit"
+	Complete      Action = "complete"
+	HistoryPrev   Action = "history_prev"
+	HistoryNext   Action = "history_next"
+	ScrollUp      Action = "scroll_up"
+	ScrollDown    Action = "scroll_down"
+	Copy          Action = "copy"
+	CursorLeft    Action = "cursor_left"
+	CursorRight   Action = "cursor_right"
+	LineStart     Action = "line_start"
+	LineEnd       Action = "line_end"
+	DeleteBack    Action = "delete_back"
+	DeleteForward Action = "delete_forward"
+	BranchPrev    Action = "branch_prev"
+	BranchNext    Action = "branch_next"
+	Editor        Action = "editor"
+	QuoteEditor   Action = "quote_editor"
+)
+
+// Binding lists the keys bound to an action with a description
+type Binding struct {
+	Action      Action
+	Keys        []string
+	Description string
+}
+
+// defaults are the bindings used when the keymap file does not override
+// them, in the order /keys lists them
+var defaults = []Binding{
+	{Submit, []string{"enter"}, "Send the input, or accept the selected command hint"},
+	{Newline, []string{"alt+enter", "ctrl+j"}, "Insert a line break"},
+	{Cancel, []string{"esc"}, "Cancel the request, edit or comparison, or clear the input"},
+	{Quit, []string{"ctrl+c", "ctrl+d"}, "Cancel any request and quit"},
+	{Complete, []string{"tab"}, "Complete with the selected hint"},
+	{HistoryPrev, []string{"up"}, "Previous hint, or earlier input"},
+	{HistoryNext, []string{"down"}, "Next hint, or later input"},
+	{ScrollUp, []string{"pgup"}, "Scroll the conversation up a page"},
+	{ScrollDown, []string{"pgdown"}, "Scroll the conversation down a page"},
+	{Copy, []string{"alt+c"}, "Copy the last reply to the clipboard"},
+	{CursorLeft, []string{"left"}, "Move the cursor left"},
+	{CursorRight, []string{"right"}, "Move the cursor right"},
+	{LineStart, []string{"home", "ctrl+a"}, "Move the cursor to the start"},
+	{LineEnd, []string{"end"}, "Move the cursor to the end"},
+	{DeleteBack, []string{"backspace"}, "Delete the character before the cursor"},
+	{DeleteForward, []string{"delete"}, "Delete the character at the cursor"},
+	{BranchPrev, []string{"alt+left"}, "Show the previous branch"},
+	{BranchNext, []string{"alt+right"}, "Show the next branch"},
+	{Editor, []string{"ctrl+e"}, "Compose the input in $VISUAL or $EDITOR"},
+	{QuoteEditor, []string{"alt+e"}, "Compose in the editor with the last reply quoted"},
+}
+
+// Keymap maps keys to actions
+type Keymap struct {
+	bindings []Binding
+	actions  map[string]Action
+}
+
+var (
+	current   *Keymap
+	warnings  []string
+	keymapMux sync.Mutex
+)
+
+// GetKeymapPath returns the path of the keymap file in the config directory
+func GetKeymapPath() (string, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "keys.json"), nil
+}
+
+// Load reads the keymap file and makes its bindings current. The file maps
+// action names to lists of keys; actions it leaves out keep their
+// defaults. Problems are reported by Warnings and never stop startup.
+func Load() {
+	overrides := map[string][]string{}
+	var problems []string
+
+	if path, err := GetKeymapPath(); err == nil {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			if err := json.Unmarshal(data, &overrides); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+				overrides = map[string][]string{}
+			}
+		} else if !os.IsNotExist(err) {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	keymap, issues := build(overrides)
+	problems = append(problems, issues...)
+
+	keymapMux.Lock()
+	defer keymapMux.Unlock()
+
+	current = keymap
+	warnings = problems
+}
+
+// build applies overrides to the default bindings and reports unknown
+// actions and conflicts. A key the file binds takes precedence over the
+// same key in a default binding; two actions the file binds to the same
+// key conflict, and the key stays with the action listed first.
+func build(overrides map[string][]string) (*Keymap, []string) {
+	var problems []string
+
+	known := map[Action]bool{}
+	for _, binding := range defaults {
+		known[binding.Action] = true
+	}
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		if !known[Action(name)] {
+			problems = append(problems, fmt.Sprintf("keymap: unknown action %q", name))
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Keys claimed by the file, so defaults give them up
+	claimed := map[string]bool{}
+	for _, name := range names {
+		for _, key := range overrides[name] {
+			claimed[normalize(key)] = true
+		}
+	}
+
+	keymap := &Keymap{actions: map[string]Action{}}
+	for _, binding := range defaults {
+		keys, overridden := overrides[string(binding.Action)]
+		if !overridden {
+			keys = binding.Keys
+		}
+
+		var bound []string
+		for _, key := range keys {
+			key = normalize(key)
+			if key == "" {
+				continue
+			}
+			if !overridden && claimed[key] {
+				continue
+			}
+			if other, taken := keymap.actions[key]; taken {
+				problems = append(problems, fmt.Sprintf("keymap: %s is bound to both %s and %s; keeping %s", key, other, binding.Action, other))
+				continue
+			}
+			keymap.actions[key] = binding.Action
+			bound = append(bound, key)
+		}
+		keymap.bindings = append(keymap.bindings, Binding{binding.Action, bound, binding.Description})
+	}
+	return keymap, problems
+}
+
+// normalize lowercases a key name and accepts "space" for the space bar
+func normalize(key string) string {
+	if key == " " {
+		return key
+	}
+	key = strings.ToLower(strings.TrimSpace(key))
+	if key == "space" {
+		return " "
+	}
+	return key
+}
+
+// Current returns the keymap in use, the defaults if none was loaded
+func Current() *Keymap {
+	keymapMux.Lock()
+	defer keymapMux.Unlock()
+
+	if current == nil {
+		current, _ = build(nil)
+	}
+	return current
+}
+
+// Warnings returns the problems found while loading the keymap
+func Warnings() []string {
+	keymapMux.Lock()
+	defer keymapMux.Unlock()
+
+	return append([]string{}, warnings...)
+}
+
+// Lookup returns the action bound to a key press, or None
+func Lookup(msg tea.KeyMsg) Action {
+	return Current().actions[msg.String()]
+}
+
+// Bindings returns the effective bindings in display order
+func (k *Keymap) Bindings() []Binding {
+	return k.bindings
+}
+
+// Keys returns the keys bound to an action, for hints shown in the UI
+func (k *Keymap) Keys(action Action) []string {
+	for _, binding := range k.bindings {
+		if binding.Action == action {
+			return binding.Keys
+		}
+	}
+	return nil
+}
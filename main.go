@@ -1,43 +1,63 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"unicode"
 
 	"codeaid/cmds"
 	"codeaid/config"
+	"codeaid/conversation"
+	"codeaid/editor"
+	"codeaid/keys"
 	"codeaid/messages"
+	"codeaid/server"
+	"codeaid/theme"
 	"codeaid/utils"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/glamour"
 	"github.com/charmbracelet/lipgloss"
 )
 
-// Message represents a single chat message
+// Message represents a single chat message as displayed; it is derived
+// from the conversation store on every render
 type Message struct {
 	Content     string
 	IsUser      bool
 	IsCommand   bool
+	IsError     bool
+	Attachments []string // Paths of images sent with a user message
+	Meta        *conversation.Metadata // Request details shown under a reply
 }
 
 // Model represents the application state
 type model struct {
-	input            string
-	cursorPosition   int
-	messages         []Message
+	input            editor.Buffer
 	loading          bool
 	animationTick    int
 	viewport         viewport
 	markdownRenderer *glamour.TermRenderer
-	hints            []string
+	hints            []cmds.Completion
 	selectedHint     int
 	showHints        bool
 	configMode       bool
 	configStep       string
 	configData       *config.Data
+	profile          string
+	editIndex        int
+	queue            []string // Input submitted while a request was in flight
+	comparison       *messages.CompareMsg // Answers shown by /compare until one is picked
+	history          []string             // Submitted input, oldest first
+	historyIndex     int                  // Position while recalling history; len(history) is the draft
+	draft            string               // Input being typed before recalling history
+	scroll           int                  // Lines scrolled up from the latest messages
+	cache            *renderCache         // Rendered messages kept between frames
+	unfocused        bool                 // The terminal reported losing focus
+	titleMarked      bool                 // The window title shows an unseen notification
 }
 
 // Viewport manages the visible area of the chat
@@ -115,32 +135,173 @@ func containsMarkdown(content string) bool {
 	return false
 }
 
+// insertText inserts text at the cursor and updates the hints
+func (m *model) insertText(text string) {
+	m.input.Insert(text)
+	m.refreshHints()
+}
+
+// refreshHints updates the hints for the current input
+func (m *model) refreshHints() {
+	m.hints = getCommandHints(m.input.String())
+	if len(m.hints) > 0 {
+		m.showHints = true
+		m.selectedHint = 0
+	} else {
+		m.showHints = false
+	}
+}
+
+// remember adds submitted input to the history
+func (m *model) remember(input string) {
+	if len(m.history) == 0 || m.history[len(m.history)-1] != input {
+		m.history = append(m.history, input)
+	}
+	m.historyIndex = len(m.history)
+	m.draft = ""
+}
+
+// recall replaces the input with an earlier (delta -1) or later (delta 1)
+// history entry; moving past the newest entry restores the draft
+func (m *model) recall(delta int) {
+	index := m.historyIndex + delta
+	if index < 0 || index > len(m.history) {
+		return
+	}
+	if m.historyIndex == len(m.history) {
+		m.draft = m.input.String()
+	}
+	m.historyIndex = index
+	if index == len(m.history) {
+		m.input.SetText(m.draft)
+	} else {
+		m.input.SetText(m.history[index])
+	}
+	m.showHints = false
+}
+
+// pickComparison continues the conversation with the n-th answer of the
+// open comparison and closes it
+func (m *model) pickComparison(n int) {
+	if m.comparison == nil {
+		utils.AddNote("Error: there is no comparison to pick from; run /compare first")
+		return
+	}
+	if n < 1 || n > len(m.comparison.Results) {
+		utils.AddNote(fmt.Sprintf("Error: pick an answer between 1 and %d", len(m.comparison.Results)))
+		return
+	}
+	result := m.comparison.Results[n-1]
+	if result.Error != "" {
+		utils.AddNote(fmt.Sprintf("Error: answer %d failed: %s", n, result.Error))
+		return
+	}
+	if err := utils.ContinueWith(m.comparison.TurnID, result); err != nil {
+		utils.AddNote("Error: " + err.Error())
+	} else {
+		utils.AddNote("Continuing with the answer from " + result.Model)
+	}
+	m.comparison = nil
+}
+
+// scrollPage is the number of lines a scroll key moves
+func (m model) scrollPage() int {
+	return max(m.viewport.height-8, 1)
+}
+
+// maxScroll is the furthest the conversation can be scrolled up
+func (m model) maxScroll() int {
+	lines := strings.Count(m.renderConversation(theme.Current().Styles(m.viewport.width-2), 0), "\n")
+	return max(lines-1, 0)
+}
+
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	wasLoading := m.loading
+	updated, cmd := m.update(msg)
+
+	// Send queued input once the previous request has finished
+	next := updated.(model)
+	if next.loading || next.configMode || len(next.queue) == 0 {
+		return updated, cmd
+	}
+	input := next.queue[0]
+	next.queue = next.queue[1:]
+	next.loading = true
+	cmds := []tea.Cmd{cmd, utils.ProcessUserInput(input)}
+	if !wasLoading {
+		// The animation stopped while the queue was waiting; restart it
+		cmds = append(cmds, utils.TickAnimation())
+	}
+	return next, tea.Batch(cmds...)
+}
+
+// update handles a single message; Update wraps it to drain the queue
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		// Use key types for all special keys for better reliability
-		switch msg.Type {
-		case tea.KeyCtrlC, tea.KeyEsc:
-			// Cancel current operation or exit
+		// The vi or Emacs editing mode sees keys first; the rest are looked
+		// up in the keymap, and unbound keys type text
+		action := keys.Lookup(msg)
+		if !m.configMode {
+			if used, mapped := m.input.HandleKey(msg); used {
+				if mapped == keys.None {
+					m.refreshHints()
+					return m, nil
+				}
+				action = mapped
+			}
+		}
+
+		switch action {
+		case keys.Cancel:
+			// Leave edit mode without resending
+			if m.editIndex > 0 && !m.loading {
+				m.editIndex = 0
+				m.input.Reset()
+				return m, nil
+			}
+
+			// Dismiss a comparison without picking an answer
+			if m.comparison != nil && !m.loading {
+				m.comparison = nil
+				return m, nil
+			}
+
+			// Cancel the current operation
 			if m.loading {
-				// Stop loading without adding a response to the conversation
+				// Stop loading without adding a response to the conversation,
+				// and drop anything queued behind it
 				m.loading = false
+				m.queue = nil
 				// Cancel the actual API request first
 				utils.CancelCurrentRequest()
 				return m, nil
 			}
+
+			// Otherwise clear the input, keeping it for undo. In vi normal
+			// mode Esc has nothing left to do, as in vi.
+			if !m.input.Normal() {
+				m.input.Clear()
+			}
+			m.showHints = false
+			return m, nil
+
+		case keys.Quit:
+			if m.loading {
+				utils.CancelCurrentRequest()
+			}
 			return m, tea.Quit
 
-		case tea.KeyEnter:
-			// If hints are shown and a hint is selected, use it instead
-			if m.showHints && len(m.hints) > 0 && m.selectedHint >= 0 && m.selectedHint < len(m.hints) {
-				m.input = m.hints[m.selectedHint]
-				m.cursorPosition = len(m.input)
+		case keys.Submit:
+			// If command name hints are shown and a hint is selected, use it instead.
+			// Argument hints are only accepted with Tab so Enter still submits.
+			if m.showHints && len(m.hints) > 0 && m.selectedHint >= 0 && m.selectedHint < len(m.hints) && !strings.Contains(m.input.String(), " ") {
+				m.input.SetText(m.hints[m.selectedHint].Value)
 				m.showHints = false
 				return m, nil
 			}
 
-			if m.input == "" && !m.configMode {
+			if m.input.Len() == 0 && !m.configMode {
 				return m, nil
 			}
 
@@ -150,7 +311,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				switch m.configStep {
 				case "api_key":
 					// Handle API key input
-					apiKey := m.input
+					apiKey := m.input.String()
 					if apiKey == "" && m.configData.OpenRouterAPIKey != "" {
 						// Keep existing value if empty input
 						apiKey = m.configData.OpenRouterAPIKey
@@ -158,8 +319,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.configData.OpenRouterAPIKey = apiKey
 					
 					// Move to model selection
-					m.input = ""
-					m.cursorPosition = 0
+					m.input.Reset()
 					
 					// Prepare model selection message
 					return m, func() tea.Msg {
@@ -173,14 +333,13 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					}
 					
 				case "model":
-					modelChoice := m.input
+					modelChoice := m.input.String()
 					if modelChoice == "" {
 						// Keep existing model if empty input
 						// Skip to save
 					} else if modelChoice == "7" || strings.ToLower(modelChoice) == "custom" {
 						// Move to custom model input
-						m.input = ""
-						m.cursorPosition = 0
+						m.input.Reset()
 						m.configStep = "custom_model"
 						return m, func() tea.Msg {
 							return messages.ConfigMsg{
@@ -209,8 +368,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 							// Complete config
 							m.configMode = false
 							m.configStep = ""
-							m.input = ""
-							m.cursorPosition = 0
+							m.input.Reset()
 							return m, func() tea.Msg {
 								return messages.ConfigMsg{
 									Type:     "complete",
@@ -222,7 +380,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					
 				case "custom_model":
 					// Handle custom model input
-					customModel := m.input
+					customModel := m.input.String()
 					if customModel != "" {
 						m.configData.Model = customModel
 					}
@@ -234,8 +392,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						// Complete config
 						m.configMode = false
 						m.configStep = ""
-						m.input = ""
-						m.cursorPosition = 0
+						m.input.Reset()
 						return m, func() tea.Msg {
 							return messages.ConfigMsg{
 								Type: "complete",
@@ -249,27 +406,43 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.configMode = false
 				m.configStep = ""
 				m.configData = nil
-				m.input = ""
-				m.cursorPosition = 0
+				m.input.Reset()
 				return m, nil
 			}
 
-			// Cancel existing operation if needed
+			// Remember the input for history and return to the latest messages
+			m.remember(m.input.String())
+			m.scroll = 0
+
+			// Queue the input until the request in flight has finished
 			if m.loading {
-				// Cancel the actual API request first
-				utils.CancelCurrentRequest()
-				// Set loading to false
-				m.loading = false
-				// Process the new input right away
-				return m, utils.ProcessUserInput(m.input)
+				m.queue = append(m.queue, m.input.String())
+				m.input.Reset()
+				m.showHints = false
+				return m, nil
 			}
 
-			// Process new user input
-			userInput := m.input
-			m.messages = append(m.messages, Message{Content: userInput, IsUser: true})
+			// Resend an edited message as a new branch
+			if m.editIndex > 0 && !strings.HasPrefix(m.input.String(), "/") {
+				if err := utils.EditTurn(m.editIndex, m.input.String()); err != nil {
+					utils.AddNote("Error: " + err.Error())
+					return m, nil
+				}
+				m.editIndex = 0
+				m.input.Reset()
+				m.showHints = false
+				m.loading = true
+				return m, tea.Batch(utils.TickAnimation(), utils.FetchPending())
+			}
+
+			// Process new user input; a comparison left open is dismissed
+			// unless the input is /compare, which may pick one of its answers
+			userInput := m.input.String()
+			if name, _, _ := strings.Cut(userInput, " "); name != "/compare" {
+				m.comparison = nil
+			}
 			m.loading = true
-			m.input = ""
-			m.cursorPosition = 0
+			m.input.Reset()
 			m.showHints = false
 
 			// Run loading animation and process user input (checking for commands)
@@ -278,55 +451,43 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				utils.ProcessUserInput(userInput),
 			)
 
-		case tea.KeyBackspace:
-			if len(m.input) > 0 && m.cursorPosition > 0 {
-				// Remove character before cursor
-				m.input = m.input[:m.cursorPosition-1] + m.input[m.cursorPosition:]
-				m.cursorPosition--
+		case keys.Newline:
+			m.insertText("\n")
 
-				// Update hints based on new input
-				m.hints = getCommandHints(m.input)
-				if len(m.hints) > 0 {
-					m.showHints = true
-					m.selectedHint = 0
-				} else {
-					m.showHints = false
-				}
+		case keys.DeleteBack:
+			if m.input.DeleteBack() {
+				m.refreshHints()
 			}
 
-		case tea.KeyDelete:
-			if len(m.input) > 0 && m.cursorPosition < len(m.input) {
-				// Remove character at cursor
-				m.input = m.input[:m.cursorPosition] + m.input[m.cursorPosition+1:]
-
-				// Update hints based on new input
-				m.hints = getCommandHints(m.input)
-				if len(m.hints) > 0 {
-					m.showHints = true
-					m.selectedHint = 0
-				} else {
-					m.showHints = false
-				}
+		case keys.DeleteForward:
+			if m.input.DeleteForward() {
+				m.refreshHints()
 			}
 
-		case tea.KeyLeft:
-			if m.cursorPosition > 0 {
-				m.cursorPosition--
-			}
+		case keys.CursorLeft:
+			m.input.Left()
 
-		case tea.KeyRight:
-			if m.cursorPosition < len(m.input) {
-				m.cursorPosition++
-			}
+		case keys.CursorRight:
+			m.input.Right()
+
+		case keys.LineStart:
+			m.input.Home()
 
-		case tea.KeyHome:
-			m.cursorPosition = 0
+		case keys.LineEnd:
+			m.input.End()
 
-		case tea.KeyEnd:
-			m.cursorPosition = len(m.input)
+		case keys.BranchPrev:
+			if !m.loading {
+				utils.SwitchBranch(-1)
+			}
+
+		case keys.BranchNext:
+			if !m.loading {
+				utils.SwitchBranch(1)
+			}
 
-		case tea.KeyUp:
-			// Handle up key for hint navigation
+		case keys.HistoryPrev:
+			// Navigate hints when shown, otherwise earlier input
 			if m.showHints && len(m.hints) > 0 {
 				if m.selectedHint > 0 {
 					m.selectedHint--
@@ -334,10 +495,12 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					// Wrap around to the last hint
 					m.selectedHint = len(m.hints) - 1
 				}
+			} else {
+				m.recall(-1)
 			}
 
-		case tea.KeyDown:
-			// Handle down key for hint navigation
+		case keys.HistoryNext:
+			// Navigate hints when shown, otherwise later input
 			if m.showHints && len(m.hints) > 0 {
 				if m.selectedHint < len(m.hints)-1 {
 					m.selectedHint++
@@ -345,75 +508,114 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					// Wrap around to the first hint
 					m.selectedHint = 0
 				}
-			}
-
-		case tea.KeySpace:
-			// Handle space key
-			if m.cursorPosition == len(m.input) {
-				m.input += " "
 			} else {
-				m.input = m.input[:m.cursorPosition] + " " + m.input[m.cursorPosition:]
+				m.recall(1)
 			}
-			m.cursorPosition++
 
-		case tea.KeyTab:
-			// Handle tab key for autocomplete
+		case keys.Complete:
 			if m.showHints && len(m.hints) > 0 && m.selectedHint >= 0 && m.selectedHint < len(m.hints) {
 				// Autocomplete with the selected hint
-				m.input = m.hints[m.selectedHint]
-				m.cursorPosition = len(m.input)
-				m.showHints = false
+				m.input.SetText(m.hints[m.selectedHint].Value)
+
+				// Keep completing, e.g. into a directory or the next argument
+				m.hints = getCommandHints(m.input.String())
+				m.showHints = len(m.hints) > 0 && !(len(m.hints) == 1 && m.hints[0].Value == m.input.String())
+				m.selectedHint = 0
+			}
+
+		case keys.ScrollUp:
+			m.scroll = min(m.scroll+m.scrollPage(), m.maxScroll())
+
+		case keys.ScrollDown:
+			m.scroll = max(m.scroll-m.scrollPage(), 0)
+
+		case keys.Copy:
+			reply, ok := utils.LastReply()
+			if !ok {
+				utils.AddNote("No reply to copy yet")
+			} else if cmd, err := utils.CopyToClipboard(reply); err != nil {
+				utils.AddNote("Error: " + err.Error())
+			} else {
+				utils.AddNote(fmt.Sprintf("Copied the last reply (%d characters) to the clipboard", len(reply)))
+				return m, cmd
+			}
+
+		case keys.Editor, keys.QuoteEditor:
+			if !m.configMode {
+				return m, utils.OpenEditor(m.input.String(), action == keys.QuoteEditor, false)
 			}
 
 		default:
-			// For all other keys, check if they're text input
-			if msg.Type == tea.KeyRunes {
-				// Regular character input
-				if m.cursorPosition == len(m.input) {
-					m.input += string(msg.Runes)
-				} else {
-					m.input = m.input[:m.cursorPosition] + string(msg.Runes) + m.input[m.cursorPosition:]
+			// Pick a compared answer with alt and its number; plain digits
+			// are text input
+			if m.comparison != nil && !m.loading && msg.Type == tea.KeyRunes && msg.Alt && len(msg.Runes) == 1 {
+				if n := int(msg.Runes[0] - '0'); n >= 1 && n <= len(m.comparison.Results) {
+					m.pickComparison(n)
+					return m, nil
 				}
-				m.cursorPosition++
+			}
 
-				// Update hints based on new input
-				m.hints = getCommandHints(m.input)
-				if len(m.hints) > 0 {
-					m.showHints = true
-					m.selectedHint = 0
-				} else {
-					m.showHints = false
-				}
+			// Other keys are text input
+			if msg.Type == tea.KeyRunes || msg.Type == tea.KeySpace {
+				m.insertText(string(msg.Runes))
 			}
 		}
 
 	case messages.ResponseMsg:
-		// Handle API response - check for error prefix
-		content := string(msg)
-		if strings.HasPrefix(content, "Error:") {
-			// Handle error by showing it in the conversation with error styling
-			m.messages = append(m.messages, Message{Content: content, IsUser: false})
-			m.loading = false
+		// Drop responses to requests that were cancelled or replaced
+		if !utils.EndRequest(msg.RequestID) {
 			return m, nil
 		}
-
-		// Handle successful response
-		m.messages = append(m.messages, Message{Content: content, IsUser: false})
+		// The reply or error is already stored on its turn; stop loading
 		m.loading = false
-		// Now that we're displaying the response, update the conversation history
-		return m, func() tea.Msg {
-			// Add message to conversation history after it's displayed
-			utils.AddMessageToHistory(content)
-			return nil
+		event, message := utils.NotifyReply, "Reply ready"
+		if strings.HasPrefix(msg.Content, "Error:") {
+			event, message = utils.NotifyError, "Request failed"
 		}
+		cmd := m.notify(event, message)
+		return m, cmd
 
 	case messages.CommandResponseMsg:
-		// Handle command response (display only, not added to conversation history)
-		content := string(msg)
-		m.messages = append(m.messages, Message{Content: content, IsUser: false, IsCommand: true})
+		// Handle command response (display only, not sent to the model)
+		utils.AddNote(string(msg))
 		m.loading = false
 		return m, nil
 			
+	case messages.EditorMsg:
+		// Load the text saved in the external editor as the input
+		if msg.Command {
+			m.loading = false
+		}
+		if msg.Err != nil {
+			utils.AddNote("Error: editor: " + msg.Err.Error())
+			return m, nil
+		}
+		m.input.SetText(msg.Content)
+		m.refreshHints()
+		return m, nil
+
+	case messages.PluginMsg:
+		// Handle plugin output: show display text, then optionally send a prompt
+		if msg.Display != "" {
+			utils.AddNote(msg.Display)
+		}
+		if msg.Prompt == "" {
+			m.loading = false
+			return m, nil
+		}
+		return m, utils.FetchReply(msg.Prompt)
+
+	case messages.ProfileMsg:
+		// Profile switched: remember it for display and confirm
+		m.profile = msg.Name
+		content := fmt.Sprintf("Using top-level settings (model %s)", msg.Model)
+		if msg.Name != "" {
+			content = fmt.Sprintf("Switched to profile %s (model %s)", msg.Name, msg.Model)
+		}
+		utils.AddNote(content)
+		m.loading = false
+		return m, nil
+
 	case messages.ConfigMsg:
 		// Handle all config messages in one case
 		configMsg := msg
@@ -429,11 +631,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 			}
 			
-			m.messages = append(m.messages, Message{
-				Content:   configMsg.PromptText,
-				IsUser:    false,
-				IsCommand: true,
-			})
+			utils.AddNote(configMsg.PromptText)
 			
 			m.loading = false
 			// Set up for special input handling
@@ -456,11 +654,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 			}
 			
-			m.messages = append(m.messages, Message{
-				Content:   content,
-				IsUser:    false,
-				IsCommand: true,
-			})
+			utils.AddNote(content)
 			
 			m.configStep = configMsg.ConfigStep
 			if cfg, ok := configMsg.Config.(*config.Data); ok {
@@ -469,11 +663,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			
 		case "complete":
 			// Config complete
-			m.messages = append(m.messages, Message{
-				Content:   fmt.Sprintf("Configuration saved to %s", configMsg.FilePath),
-				IsUser:    false,
-				IsCommand: true,
-			})
+			utils.AddNote(fmt.Sprintf("Configuration saved to %s", configMsg.FilePath))
 			m.configMode = false
 			m.configStep = ""
 			m.configData = nil
@@ -486,15 +676,26 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		helpMsg := msg
 		var sb strings.Builder
 		
-		// Style the header using styles from the View function
-		headerStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("3"))
-		sb.WriteString(headerStyle.Render(helpMsg.Header) + "\n")
-		
-		// Style for command names
-		cmdStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("4"))
-		// Style for descriptions
-		descStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("7"))
+		// Style the help with the current theme
+		styles := theme.Current().Styles(m.viewport.width - 2)
+		sb.WriteString(styles.Heading.Render(helpMsg.Header) + "\n")
+		cmdStyle := styles.CommandName
+		descStyle := styles.Description
 		
+		// Usage and examples for single-command help
+		if helpMsg.Usage != "" {
+			sb.WriteString("Usage: " + cmdStyle.Render(helpMsg.Usage) + "\n")
+		}
+		if len(helpMsg.Examples) > 0 {
+			sb.WriteString("Examples:\n")
+			for _, example := range helpMsg.Examples {
+				sb.WriteString("  " + descStyle.Render(example) + "\n")
+			}
+		}
+		if helpMsg.Usage != "" && len(helpMsg.Commands) > 0 {
+			sb.WriteString("Subcommands:\n")
+		}
+
 		for _, cmd := range helpMsg.Commands {
 			sb.WriteString(cmdStyle.Render(cmd.Name))
 			sb.WriteString(" - ")
@@ -502,21 +703,66 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			sb.WriteString("\n")
 		}
 		
-		// Add the styled help content to messages
-		m.messages = append(m.messages, Message{Content: sb.String(), IsUser: false, IsCommand: true})
+		// Add the styled help content to the conversation as a note
+		utils.AddNote(sb.String())
+		m.loading = false
+		return m, nil
+
+	case messages.HistoryChangedMsg:
+		// The active branch changed; the view follows the store
+		if msg.Fetch {
+			return m, utils.FetchPending()
+		}
+		m.loading = false
+		return m, nil
+
+	case messages.EditMsg:
+		// Load the message into the input box; Enter resends it as a new branch
+		m.editIndex = msg.Index
+		m.input.SetText(msg.Prompt)
 		m.loading = false
 		return m, nil
 
 	case messages.ClearHistoryMsg:
-		// Clear the chat history in the UI
-		m.messages = []Message{
+		// The store was cleared, which also clears the view
+		m.loading = false
+		return m, nil
+
+	case messages.ComparePickMsg:
+		m.loading = false
+		m.pickComparison(msg.Index)
+		return m, nil
+
+	case messages.CompareMsg:
+		// Show the answers side by side until one is picked
+		if !utils.EndRequest(msg.RequestID) {
+			return m, nil
 		}
+		m.comparison = &msg
 		m.loading = false
+		cmd := m.notify(utils.NotifyReply, fmt.Sprintf("%d answers ready to compare", len(msg.Results)))
+		return m, cmd
+
+	case tea.BlurMsg:
+		m.unfocused = true
+		return m, nil
+
+	case tea.FocusMsg:
+		// The user is back, so any title marker has been seen
+		m.unfocused = false
+		if m.titleMarked {
+			m.titleMarked = false
+			return m, utils.ClearTitleMarker()
+		}
 		return m, nil
 
 	case messages.CancelMsg:
-		// The request was canceled via context cancellation
-		// Just set loading to false without adding any message to UI or history
+		// The request was canceled via context cancellation. Cancelling from
+		// the UI already stopped loading, so only unexpected cancellations
+		// are handled here.
+		if !utils.EndRequest(msg.RequestID) {
+			return m, nil
+		}
 		m.loading = false
 		return m, nil
 
@@ -539,56 +785,27 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m model) View() string {
-	// Define styles using default terminal colors where possible
-	styles := struct {
-		header       lipgloss.Style
-		user         lipgloss.Style
-		ai           lipgloss.Style
-		error        lipgloss.Style
-		loading      lipgloss.Style
-		input        lipgloss.Style
-		active       lipgloss.Style
-		hint         lipgloss.Style
-		hintSelected lipgloss.Style
-		command      lipgloss.Style
-	}{
-		header:       lipgloss.NewStyle().Bold(true).Width(m.viewport.width - 2),
-		user:         lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Width(m.viewport.width - 2),
-		ai:           lipgloss.NewStyle().Width(m.viewport.width - 2),
-		error:        lipgloss.NewStyle().Foreground(lipgloss.Color("9")).Bold(true).Width(m.viewport.width - 2),
-		loading:      lipgloss.NewStyle().Foreground(lipgloss.Color("3")),
-		input:        lipgloss.NewStyle().BorderStyle(lipgloss.RoundedBorder()).BorderForeground(lipgloss.Color("8")).MarginBottom(1).Width(m.viewport.width - 2),
-		active:       lipgloss.NewStyle().BorderStyle(lipgloss.RoundedBorder()).BorderForeground(lipgloss.Color("0")).Width(m.viewport.width - 2),
-		hint:         lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Width(m.viewport.width - 2),
-		hintSelected: lipgloss.NewStyle().Foreground(lipgloss.Color("14")).Width(m.viewport.width - 2),
-		command:      lipgloss.NewStyle().Foreground(lipgloss.Color("8")).PaddingLeft(4).Width(m.viewport.width - 2),
-	}
-
-	// Build message history using StringBuilder for better performance
-	var conversation strings.Builder
-	for _, msg := range m.messages {
-		if msg.IsUser {
-			conversation.WriteString(styles.user.Render("> " + msg.Content))
-		} else if msg.IsCommand {
-			// Don't apply any styling for command messages as they're already styled
-			conversation.WriteString(styles.command.Render(msg.Content))
-		} else {
-			// Check if this is an error message
-			if strings.HasPrefix(msg.Content, "Error:") {
-				conversation.WriteString(styles.error.Render(msg.Content))
-			} else {
-				// No markdown rendering - display plain text with wrapping
-				conversation.WriteString(styles.ai.Render(msg.Content))
-			}
+	styles := theme.Current().Styles(m.viewport.width - 2)
+
+	// Compose only what fits on screen, plus what is scrolled past
+	m.cache.nextFrame()
+	conversation := m.renderConversation(styles, m.viewport.height+m.scroll)
+
+	// Show earlier messages when scrolled up
+	if m.scroll > 0 {
+		lines := strings.Split(conversation, "\n")
+		end := max(len(lines)-m.scroll, 1)
+		hint := fmt.Sprintf("scrolled up %d lines", len(lines)-end)
+		if scrollKeys := keys.Current().Keys(keys.ScrollDown); len(scrollKeys) > 0 {
+			hint += ", " + scrollKeys[0] + " to scroll down"
 		}
-		conversation.WriteString("\n\n")
+		conversation = strings.Join(lines[:end], "\n") + "\n\n" + styles.Hint.Render(hint) + "\n\n"
 	}
 
 	// Add loading animation if active
 	if m.loading {
 		spinner := utils.GetLoadingAnimation(m.animationTick)
-		conversation.WriteString(styles.loading.Render("Thinking " + spinner))
-		conversation.WriteString("\n\n")
+		conversation += styles.Loading.Render("Thinking "+spinner) + "\n\n"
 	}
 
 	// Render input prompt with cursor
@@ -596,29 +813,32 @@ func (m model) View() string {
 	prefix := "> "
 
 	if m.loading {
-		prompt = styles.active.Render(prefix + m.input)
+		prompt = styles.Active.Render(prefix + m.input.String())
 	} else {
-		// Display input with cursor indicator
-		if m.cursorPosition == len(m.input) {
-			// Cursor at the end
-			prompt = styles.input.Render(prefix + m.input + "▎")
+		// Display input with the character at the cursor highlighted, or a
+		// bar at the end
+		before, at, after := m.input.Split()
+		if at == "" {
+			prompt = styles.Input.Render(prefix + before + "▎")
 		} else {
-			// Cursor in the middle - highlight the character at cursor position
-			beforeCursor := m.input[:m.cursorPosition]
-			atCursor := ""
-			if m.cursorPosition < len(m.input) {
-				atCursor = string(m.input[m.cursorPosition])
-			}
-			afterCursor := ""
-			if m.cursorPosition+1 <= len(m.input) {
-				afterCursor = m.input[m.cursorPosition+1:]
-			}
-
-			cursorStyle := lipgloss.NewStyle().Background(lipgloss.Color("7"))
-			prompt = styles.input.Render(prefix + beforeCursor + cursorStyle.Render(atCursor) + afterCursor)
+			prompt = styles.Input.Render(prefix + before + styles.Cursor.Render(at) + after)
 		}
 	}
 
+	// Show the vi mode and message editing above the input box
+	if indicator := m.input.Indicator(); indicator != "" {
+		prompt = styles.Hint.Render(indicator) + "\n" + prompt
+	}
+	if staged := utils.StagedAttachments(); len(staged) > 0 {
+		prompt = renderChips(staged) + "\n" + prompt
+	}
+	for i := len(m.queue) - 1; i >= 0; i-- {
+		prompt = styles.Hint.Render(fmt.Sprintf("queued %d: %s", i+1, m.queue[i])) + "\n" + prompt
+	}
+	if m.editIndex > 0 {
+		prompt = styles.Hint.Render(fmt.Sprintf("editing message %d (Enter resends as a new branch, Esc cancels)", m.editIndex)) + "\n" + prompt
+	}
+
 	// Add hints if available
 	var hintsDisplay string
 	if m.showHints && len(m.hints) > 0 {
@@ -626,11 +846,15 @@ func (m model) View() string {
 		hintsBuilder.WriteString("\n")
 
 		for i, hint := range m.hints {
+			text := " " + hint.Value + " "
+			if hint.Description != "" {
+				text += " " + hint.Description
+			}
 			if i == m.selectedHint {
 				// Highlight the selected hint
-				hintsBuilder.WriteString(styles.hintSelected.Render(" " + hint + " "))
+				hintsBuilder.WriteString(styles.HintSelected.Render(text))
 			} else {
-				hintsBuilder.WriteString(styles.hint.Render(" " + hint + " "))
+				hintsBuilder.WriteString(styles.Hint.Render(text))
 			}
 			hintsBuilder.WriteString("\n") // Add newline for vertical display
 		}
@@ -638,39 +862,379 @@ func (m model) View() string {
 	}
 
 	// Combine all elements
+	view := fmt.Sprintf("%s\n\n%s", conversation, prompt)
 	if m.showHints && len(m.hints) > 0 {
-		return fmt.Sprintf("%s\n\n%s%s", conversation.String(), prompt, hintsDisplay)
+		view += hintsDisplay
+	}
+
+	// Finish with the status line below the input and any hints
+	if status := m.statusLine(m.viewport.width - 2); status != "" {
+		view = strings.TrimSuffix(view, "\n") + "\n" + styles.Footer.Render(status)
+	}
+	return view
+}
+
+// notify announces a finished request while the terminal is unfocused,
+// once nothing more is queued
+func (m *model) notify(event, message string) tea.Cmd {
+	if !m.unfocused || len(m.queue) > 0 {
+		return nil
+	}
+	cmd, marked := utils.Notify(event, message)
+	if marked {
+		m.titleMarked = true
+	}
+	return cmd
+}
+
+// renderConversation renders the messages on the current branch and any
+// open comparison. Only the messages needed to fill the last limit lines
+// are composed; a limit of 0 renders them all.
+func (m model) renderConversation(styles theme.Styles, limit int) string {
+	width := m.viewport.width - 2
+	current := theme.Current()
+
+	// Work back from the newest message, so messages scrolled far out of
+	// view are not even looked up
+	var blocks []renderedBlock
+	lines := 0
+	add := func(block renderedBlock) {
+		blocks = append(blocks, block)
+		lines += block.lines + 1
+	}
+
+	// Show compared answers below the conversation
+	if m.comparison != nil {
+		text := renderComparison(m.comparison, width)
+		add(renderedBlock{text: text, lines: strings.Count(text, "\n") + 1})
+	}
+
+	msgs := chatMessages()
+	for i := len(msgs) - 1; i >= 0 && (limit == 0 || lines < limit); i-- {
+		msg := msgs[i]
+		key := renderKey{width: width, theme: current, kind: messageKind(msg), text: msg.Content}
+		if len(msg.Attachments) > 0 {
+			key.text += "\x00" + strings.Join(msg.Attachments, "\x00")
+		}
+		if msg.Meta != nil {
+			key.text += "\x00" + formatMetadata(*msg.Meta)
+		}
+		add(m.cache.get(key, func() string {
+			return renderMessage(msg, styles)
+		}))
+	}
+
+	var conversation strings.Builder
+	for i := len(blocks) - 1; i >= 0; i-- {
+		conversation.WriteString(blocks[i].text)
+		conversation.WriteString("\n\n")
+	}
+	return conversation.String()
+}
+
+// messageKind names how a message is styled, for the render cache
+func messageKind(msg Message) string {
+	switch {
+	case msg.IsUser:
+		return "user"
+	case msg.IsError:
+		return "error"
+	case msg.IsCommand:
+		return "command"
+	}
+	return "reply"
+}
+
+// renderMessage wraps and styles a single message
+func renderMessage(msg Message, styles theme.Styles) string {
+	var conversation strings.Builder
+	if msg.IsUser {
+		conversation.WriteString(styles.User.Render("> " + msg.Content))
+		if len(msg.Attachments) > 0 {
+			conversation.WriteString("\n" + renderChips(msg.Attachments))
+		}
+	} else if msg.IsError {
+		conversation.WriteString(styles.Error.Render(msg.Content))
+	} else if msg.IsCommand {
+		// Don't apply any styling for command messages as they're already styled
+		conversation.WriteString(styles.Command.Render(msg.Content))
+	} else {
+		// Check if this is an error message
+		if strings.HasPrefix(msg.Content, "Error:") {
+			conversation.WriteString(styles.Error.Render(msg.Content))
+		} else {
+			// No markdown rendering - display plain text with wrapping
+			conversation.WriteString(styles.Reply.Render(msg.Content))
+		}
+		if msg.Meta != nil {
+			conversation.WriteString("\n")
+			if msg.Meta.Truncated() {
+				conversation.WriteString(styles.Truncated.Render(formatMetadata(*msg.Meta) + " · cut off at the token limit, /continue to resume"))
+			} else {
+				conversation.WriteString(styles.Footer.Render(formatMetadata(*msg.Meta)))
+			}
+		}
+	}
+	return conversation.String()
+}
+
+// formatMetadata summarises how a reply was produced for its footer
+func formatMetadata(meta conversation.Metadata) string {
+	parts := []string{meta.Model, fmt.Sprintf("%.1fs", meta.Latency.Seconds())}
+	if meta.PromptTokens > 0 || meta.CompletionTokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d prompt + %d completion tokens", meta.PromptTokens, meta.CompletionTokens))
+	}
+	if meta.FinishReason != "" {
+		parts = append(parts, "finish: "+meta.FinishReason)
+	}
+	return strings.Join(parts, " · ")
+}
+
+// renderChips shows attached images as compact labels
+func renderChips(paths []string) string {
+	chip := theme.Current().Styles(0).Chip
+	chips := make([]string, 0, len(paths))
+	for _, path := range paths {
+		chips = append(chips, chip.Render("image: "+filepath.Base(path)))
+	}
+	return strings.Join(chips, " ")
+}
+
+// minCompareColumnWidth is the narrowest column used for /compare; below
+// it the answers are stacked instead
+const minCompareColumnWidth = 30
+
+// renderComparison lays out the answers of a comparison in columns, or
+// stacked when the terminal is too narrow
+func renderComparison(cmp *messages.CompareMsg, width int) string {
+	gap := 2
+	count := len(cmp.Results)
+	columnWidth := (width - gap*(count-1)) / count
+	stacked := columnWidth < minCompareColumnWidth
+	if stacked {
+		columnWidth = width
+	}
+
+	styles := theme.Current().Styles(0)
+	header := lipgloss.NewStyle().Bold(true)
+	meta := styles.Footer
+	errorStyle := styles.Error.UnsetBold()
+	column := lipgloss.NewStyle().Width(columnWidth)
+
+	columns := make([]string, 0, count)
+	for i, result := range cmp.Results {
+		var sb strings.Builder
+		sb.WriteString(header.Render(fmt.Sprintf("%d. %s", i+1, result.Model)))
+		sb.WriteString("\n")
+		if result.Error != "" {
+			sb.WriteString(meta.Render(fmt.Sprintf("%.1fs", result.Latency.Seconds())))
+			sb.WriteString("\n\n")
+			sb.WriteString(errorStyle.Render("Error: " + result.Error))
+		} else {
+			details := fmt.Sprintf("%.1fs · %d prompt + %d completion tokens",
+				result.Latency.Seconds(), result.PromptTokens, result.CompletionTokens)
+			if result.FinishReason == conversation.FinishReasonLength {
+				sb.WriteString(errorStyle.Render(details + " · cut off"))
+			} else {
+				sb.WriteString(meta.Render(details))
+			}
+			sb.WriteString("\n\n")
+			sb.WriteString(result.Reply)
+		}
+		columns = append(columns, column.Render(sb.String()))
+	}
+
+	var body string
+	if stacked {
+		body = strings.Join(columns, "\n\n")
 	} else {
-		return fmt.Sprintf("%s\n\n%s", conversation.String(), prompt)
+		spacer := strings.Repeat(" ", gap)
+		joined := []string{}
+		for i, col := range columns {
+			if i > 0 {
+				joined = append(joined, spacer)
+			}
+			joined = append(joined, col)
+		}
+		body = lipgloss.JoinHorizontal(lipgloss.Top, joined...)
 	}
+
+	help := meta.Render(fmt.Sprintf("Press alt+1-%d or type /compare pick N to continue with an answer, Esc to dismiss", count))
+	return body + "\n\n" + help
 }
 
-// getCommandHints returns a list of command hints that match the current input
-func getCommandHints(input string) []string {
+// chatMessages derives the displayed messages from the active branch of
+// the conversation store, so the view always matches what is sent
+func chatMessages() []Message {
+	msgs := []Message{}
+	for _, turn := range utils.ConversationPath() {
+		switch turn.Kind {
+		case conversation.KindInjected:
+			for _, injected := range turn.Injected {
+				msgs = append(msgs, Message{Content: fmt.Sprintf("[%s] %s", injected.Role, injected.Content), IsCommand: true})
+			}
+
+		case conversation.KindNote:
+			if turn.Prompt != "" {
+				msgs = append(msgs, Message{Content: turn.Prompt, IsUser: true})
+			}
+			if turn.Reply != "" {
+				msgs = append(msgs, Message{Content: turn.Reply, IsCommand: true})
+			}
+
+		case conversation.KindChat:
+			user := Message{Content: turn.Prompt, IsUser: true}
+			for _, attachment := range turn.Attachments {
+				user.Attachments = append(user.Attachments, attachment.Path)
+			}
+			msgs = append(msgs, user)
+			switch turn.State {
+			case conversation.StatePending:
+				// Show the reply as it streams in
+				if turn.Reply != "" {
+					msgs = append(msgs, Message{Content: turn.Reply})
+				}
+			case conversation.StateCompleted:
+				reply := Message{Content: turn.Reply}
+				if turn.Meta.Model != "" {
+					meta := turn.Meta
+					reply.Meta = &meta
+				}
+				msgs = append(msgs, reply)
+			case conversation.StateFailed:
+				msgs = append(msgs, Message{Content: "Error: " + turn.Error, IsError: true})
+			case conversation.StateCancelled:
+				msgs = append(msgs, Message{Content: "(cancelled, not sent)", IsCommand: true})
+			}
+		}
+	}
+	return msgs
+}
+
+// getCommandHints returns a list of command and argument hints for the current input
+func getCommandHints(input string) []cmds.Completion {
 	// If input is empty or doesn't start with '/', return no hints
 	if len(input) == 0 || input[0] != '/' {
 		return nil
 	}
 
 	// Use the command registry to find matches
-	return cmds.FindMatchingCommands(input)
+	return cmds.FindHints(input)
+}
+
+// newModel creates the initial model with a default window size for
+// proper text wrapping
+func newModel() model {
+	m := model{
+		cache: newRenderCache(),
+		viewport: viewport{
+			width:  80, // Default width, will be updated on first WindowSizeMsg
+			height: 24, // Default height, will be updated on first WindowSizeMsg
+		},
+		hints:        []cmds.Completion{},
+		selectedHint: -1,
+		showHints:    false,
+		profile:      utils.ActiveProfile(),
+	}
+
+	// Use the configured vi or Emacs editing mode for the input
+	if resolved, err := config.Resolve(); err == nil {
+		m.input.SetMode(editor.ParseMode(resolved.Data.EditMode))
+	}
+	return m
 }
 
 func main() {
-	// Check command-line arguments for --config flag
-	configMode := false
-	for _, arg := range os.Args {
-		if arg == "--config" {
-			configMode = true
-			break
+	// Parse command-line flags; flags form the highest configuration layer
+	configMode := flag.Bool("config", false, "Run the configuration setup")
+	modelFlag := flag.String("model", "", "Model to use for this session")
+	profileFlag := flag.String("profile", "", "Configuration profile to use")
+	apiKeyFlag := flag.String("api-key", "", "OpenRouter API key for this session")
+	encryptSecrets := flag.Bool("encrypt-secrets", false, "Move the API key into a passphrase-encrypted secrets file")
+	sessionFlag := flag.String("session", "", "Name shown for this session in the status line")
+	debugFlag := flag.Bool("debug", false, "Log every API exchange, with secrets redacted, to the debug log")
+	recordFlag := flag.String("record", "", "Record API exchanges as cassettes in this directory")
+	replayFlag := flag.String("replay", "", "Answer requests from cassettes in this directory, offline")
+	stdioFlag := flag.Bool("stdio", false, "Speak JSON-RPC 2.0 on stdin and stdout for editor integrations")
+	scriptFlag := flag.String("script", "", "Run a keystroke script headlessly and compare snapshots with golden files")
+	updateGolden := flag.Bool("update-golden", false, "With --script, write snapshots as the new golden files")
+	flag.Parse()
+
+	// Only flags given on the command line override other layers
+	flagKeys := map[string]string{
+		"api-key": "openrouter_api_key",
+		"model":   "model",
+		"profile": "profile",
+		"debug":   "debug",
+		"session": "session_name",
+	}
+	given := []string{}
+	flag.Visit(func(f *flag.Flag) {
+		if key, ok := flagKeys[f.Name]; ok {
+			given = append(given, key)
+		}
+	})
+	config.SetFlags(config.Data{
+		OpenRouterAPIKey: *apiKeyFlag,
+		Model:            *modelFlag,
+		Profile:          *profileFlag,
+		Debug:            *debugFlag,
+		SessionName:      *sessionFlag,
+	}, given)
+
+	// Record or replay API exchanges
+	if *recordFlag != "" && *replayFlag != "" {
+		fmt.Println("Error: --record and --replay cannot be used together")
+		os.Exit(2)
+	}
+	if *recordFlag != "" {
+		if err := utils.SetCassette(utils.CassetteRecord, *recordFlag); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
 	}
+	if *replayFlag != "" {
+		if err := utils.SetCassette(utils.CassetteReplay, *replayFlag); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Handle subcommands that run without the TUI; serve can record or replay
+	if args := flag.Args(); len(args) > 0 {
+		os.Exit(runSubcommand(args))
+	}
+
+	// Serve editor integrations over stdin and stdout instead of the TUI
+	if *stdioFlag {
+		os.Exit(runStdio())
+	}
+
+	// Drive the TUI from a script with a fake provider, without a terminal
+	if *scriptFlag != "" {
+		os.Exit(runScript(*scriptFlag, *updateGolden))
+	}
 
 	// Clear screen and display logo first
 	utils.DisplayLogo()
 
+	// Unlock the encrypted secrets file, if any, before reading configuration
+	if err := config.UnlockSecretsAtStartup(); err != nil {
+		fmt.Printf("Error unlocking secrets: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Encrypt the API key and exit
+	if *encryptSecrets {
+		if err := config.RunEncryptSecrets(); err != nil {
+			fmt.Printf("Error encrypting secrets: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Run setup in config mode or first-time setup
-	if configMode {
+	if *configMode {
 		// Run setup and exit
 		fmt.Println("Entering configuration mode...")
 		err := config.RunFirstTimeSetup(true) // true means always run setup
@@ -679,12 +1243,18 @@ func main() {
 		}
 		fmt.Println("\nConfiguration completed. Press Enter to launch the application...")
 		fmt.Scanln() // Wait for Enter key
-		// Restart without --config flag
+		// Restart without --config flag, keeping any other flags
 		execPath, _ := os.Executable()
-		syscall.Exec(execPath, []string{execPath}, os.Environ())
+		restartArgs := []string{execPath}
+		for _, arg := range os.Args[1:] {
+			if arg != "--config" && arg != "-config" {
+				restartArgs = append(restartArgs, arg)
+			}
+		}
+		syscall.Exec(execPath, restartArgs, os.Environ())
 		return // This won't be reached
-	} else {
-		// Normal startup - check for first-time setup
+	} else if !utils.Replaying() {
+		// Normal startup - check for first-time setup; replaying needs no key
 		err := config.RunFirstTimeSetup(false) // false means only run if no config exists
 		if err != nil {
 			fmt.Printf("Error during setup: %v\n", err)
@@ -692,28 +1262,143 @@ func main() {
 		}
 	}
 
-	// Register command handler
-	utils.SetCommandHandler(cmds.CommandRegistry{})
+	// Report configuration problems, such as invalid fields or files whose
+	// permissions had to be tightened
+	resolved, err := resolveConfig()
 
-	// Create initial model with default window size for proper text wrapping
-	initialModel := model{
-		messages:       []Message{},
-		cursorPosition: 0,
-		viewport: viewport{
-			width:  80, // Default width, will be updated on first WindowSizeMsg
-			height: 24, // Default height, will be updated on first WindowSizeMsg
-		},
-		hints:        []string{},
-		selectedHint: -1,
-		showHints:    false,
+	// Fail early on an unknown profile from --profile or CODEAID_PROFILE
+	if err == nil {
+		_, err = resolved.Settings()
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Pick the theme while the terminal can still answer the background
+	// colour query
+	if err := theme.Use(resolved.Data.Theme); err != nil {
+		fmt.Printf("Warning: %v, using the automatic theme\n", err)
+	}
+
+	// Load key bindings, reporting unknown actions and conflicts
+	keys.Load()
+	for _, warning := range keys.Warnings() {
+		fmt.Printf("Warning: %s\n", warning)
 	}
 
+	// Register external plugin commands and the command handler
+	cmds.LoadPlugins()
+	utils.SetCommandHandler(cmds.CommandRegistry{})
+
 	// Create program with alternateScreen option for better performance
-	p := tea.NewProgram(initialModel)
+	options := []tea.ProgramOption{tea.WithReportFocus()}
+	if out, ok := utils.NewTerminal(os.Stdout); ok {
+		// Notifications and clipboard requests share the program output
+		options = append(options, tea.WithOutput(out))
+		utils.UseTerminal(out)
+	}
+	p := tea.NewProgram(newModel(), options...)
 
 	// Run program
-	if _, err := p.Run(); err != nil {
+	_, err = p.Run()
+	utils.CloseTerminal()
+	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 }
+
+// runSubcommand runs a non-interactive subcommand and returns the exit code
+func runSubcommand(args []string) int {
+	if len(args) == 2 && args[0] == "config" && args[1] == "validate" {
+		issues, err := config.ValidateFiles()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+		if len(issues) > 0 {
+			return 1
+		}
+		fmt.Println("Configuration is valid.")
+		return 0
+	}
+
+	if args[0] == "serve" {
+		return runServe(args[1:])
+	}
+
+	fmt.Printf("Unknown command: %s\n", strings.Join(args, " "))
+	fmt.Println("Available commands:")
+	fmt.Println("  config validate    Check config files for unknown or invalid fields")
+	fmt.Println("  serve [--addr A]   Serve a local HTTP API for editor plugins and tools")
+	return 2
+}
+
+// resolveConfig resolves the configuration at startup and prints the
+// warnings found while loading it
+func resolveConfig() (*config.Resolved, error) {
+	resolved, err := config.Resolve()
+	for _, warning := range config.TakeWarnings() {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	return resolved, err
+}
+
+// runServe runs the local HTTP API server
+func runServe(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", server.DefaultAddr, "Loopback address to listen on")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := config.UnlockSecretsAtStartup(); err != nil {
+		fmt.Printf("Error unlocking secrets: %v\n", err)
+		return 1
+	}
+	if _, err := resolveConfig(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if err := server.Serve(*addr); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runStdio serves JSON-RPC on stdin and stdout. Anything else printed
+// goes to stderr so it cannot corrupt the protocol.
+func runStdio() int {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+
+	// Stdin carries the protocol, so the passphrase must come from the
+	// environment
+	if config.SecretsExist() && os.Getenv(config.PassphraseEnv) == "" {
+		fmt.Printf("Error: the secrets file is encrypted; set %s to unlock it in stdio mode\n", config.PassphraseEnv)
+		return 1
+	}
+	if err := config.UnlockSecretsAtStartup(); err != nil {
+		fmt.Printf("Error unlocking secrets: %v\n", err)
+		return 1
+	}
+
+	if _, err := resolveConfig(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	cmds.LoadPlugins()
+	utils.SetCommandHandler(cmds.CommandRegistry{})
+
+	if err := server.ServeStdio(os.Stdin, out); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"codeaid/config"
+	"codeaid/editor"
+	"codeaid/theme"
+	"codeaid/utils"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestScripts(t *testing.T) {
+	scripts, err := filepath.Glob(filepath.Join("testdata", "scripts", "*.script"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) == 0 {
+		t.Fatal("no scripts in testdata/scripts")
+	}
+
+	for _, script := range scripts {
+		t.Run(filepath.Base(script), func(t *testing.T) {
+			if code := runScript(script, false); code != 0 {
+				t.Errorf("%s failed; run it with --script to see the differences", script)
+			}
+		})
+	}
+}
+
+// benchmarkReply is a reply long enough to need wrapping and markdown
+// rendering, like a typical answer
+const benchmarkReply = `Here is how the cache works:
+
+1. Each finished message is rendered once per width and theme.
+2. Only messages that fill the screen are looked up at all.
+
+` + "```go\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n```" + `
+
+The spinner tick redraws the input and the status line, and nothing else
+changes until the next reply arrives, so **most frames hit the cache**.`
+
+// loadConversation fills the conversation with turns answered by the
+// fake provider, using an empty configuration
+func loadConversation(b *testing.B, turns int) {
+	b.Helper()
+	config.Isolate(b.TempDir())
+	b.Cleanup(func() { config.Isolate("") })
+	utils.UseFakeProvider()
+	utils.ClearHistory()
+	if err := theme.Use("dark"); err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 1; i <= turns; i++ {
+		utils.QueueFakeReply(utils.FakeReply{Content: benchmarkReply})
+		if msg := utils.FetchReply(fmt.Sprintf("Question %d: how does the render cache work?", i))(); msg == nil {
+			b.Fatal("no reply")
+		}
+	}
+	utils.CancelCurrentRequest()
+}
+
+// BenchmarkView measures a frame of a 500-turn conversation with an
+// empty render cache, with the cache filled by the previous frame, and
+// with every message composed rather than only the visible ones
+func BenchmarkView(b *testing.B) {
+	loadConversation(b, 500)
+
+	m := newModel()
+	m.viewport = viewport{width: 100, height: 40}
+
+	b.Run("cold", func(b *testing.B) {
+		for b.Loop() {
+			m.cache = newRenderCache()
+			m.View()
+		}
+	})
+
+	b.Run("warm", func(b *testing.B) {
+		m.cache = newRenderCache()
+		m.View()
+		for b.Loop() {
+			m.View()
+		}
+	})
+
+	b.Run("all lines", func(b *testing.B) {
+		styles := theme.Current().Styles(m.viewport.width - 2)
+		for b.Loop() {
+			m.cache = newRenderCache()
+			m.renderConversation(styles, 0)
+		}
+	})
+}
+
+// pressKeys sends keys to the model in turn
+func pressKeys(m model, keys ...tea.KeyMsg) model {
+	for _, key := range keys {
+		updated, _ := m.Update(key)
+		m = updated.(model)
+	}
+	return m
+}
+
+func TestViEscapeKeepsInput(t *testing.T) {
+	config.Isolate(t.TempDir())
+	t.Cleanup(func() { config.Isolate("") })
+
+	m := newModel()
+	m.input.SetMode(editor.ModeVi)
+	esc := tea.KeyMsg{Type: tea.KeyEsc}
+	m = pressKeys(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("hello")}, esc, esc)
+	if m.input.String() != "hello" || !m.input.Normal() {
+		t.Fatalf("after two Esc: input %q in %s, want hello in normal mode", m.input.String(), m.input.Indicator())
+	}
+
+	// Outside normal mode Esc clears the input, which can be undone
+	m.input.SetMode(editor.ModeDefault)
+	m = pressKeys(m, esc)
+	if m.input.String() != "" {
+		t.Fatalf("Esc left %q, want the input cleared", m.input.String())
+	}
+	if !m.input.Undo() || m.input.String() != "hello" {
+		t.Errorf("undo after Esc gave %q, want hello", m.input.String())
+	}
+}
@@ -1,7 +1,13 @@
 package messages
 
-// ResponseMsg defines a custom message type for responses
-type ResponseMsg string
+import "time"
+
+// ResponseMsg reports that an API request finished. The reply or error is
+// already stored on the request's turn.
+type ResponseMsg struct {
+	RequestID int    // ID of the request this response belongs to
+	Content   string // Reply text, or the error prefixed with "Error:"
+}
 
 // CommandResponseMsg defines a message type for command responses (displayed but not sent to LLM)
 type CommandResponseMsg string
@@ -10,6 +16,8 @@ type CommandResponseMsg string
 type HelpMsg struct {
 	Header   string
 	Commands []CommandInfo
+	Usage    string   // Set when showing help for a single command
+	Examples []string // Example invocations for a single command
 }
 
 // CommandInfo holds information about a command
@@ -19,7 +27,9 @@ type CommandInfo struct {
 }
 
 // CancelMsg is a special message type returned when an operation is canceled
-type CancelMsg struct{}
+type CancelMsg struct {
+	RequestID int // ID of the cancelled request
+}
 
 // ClearHistoryMsg is a message type to indicate history clearing
 type ClearHistoryMsg struct{}
@@ -37,4 +47,59 @@ type ConfigMsg struct {
 	FilePath    string      // For complete messages
 	CurrentKey  string      // For init messages (masked key)
 	CurrentModel string     // For init messages
-}
\ No newline at end of file
+}
+
+// PluginMsg carries the result of an external plugin command
+type PluginMsg struct {
+	Display string // Text to show in the chat (not sent to the LLM)
+	Prompt  string // Prompt to send to the LLM, if any
+}
+
+// ProfileMsg reports that the active configuration profile changed
+type ProfileMsg struct {
+	Name  string // Active profile name, empty when no profile is active
+	Model string // Model used by the active profile
+}
+
+// HistoryChangedMsg reports that the active conversation branch changed
+// and the chat should be rebuilt from it
+type HistoryChangedMsg struct {
+	Fetch bool // Request a reply for the last message on the branch
+}
+
+// EditMsg loads an earlier user message into the input box for editing
+type EditMsg struct {
+	Index  int    // 1-based position among user messages on the active branch
+	Prompt string // Current text of that message
+}
+
+// CompareResult is one model's answer in a comparison
+type CompareResult struct {
+	Model            string
+	Reply            string
+	Error            string // Set instead of Reply when the request failed
+	Latency          time.Duration
+	PromptTokens     int
+	CompletionTokens int
+	FinishReason     string
+}
+
+// CompareMsg carries the answers of several models to the same conversation
+type CompareMsg struct {
+	RequestID int             // ID of the comparison request
+	TurnID    int             // Turn whose prompt was answered
+	Prompt    string          // Prompt that was answered
+	Results   []CompareResult // One result per model, in the order requested
+}
+
+// ComparePickMsg asks to continue with an answer of the open comparison
+type ComparePickMsg struct {
+	Index int // 1-based position of the answer
+}
+
+// EditorMsg carries the text saved in an external editor
+type EditorMsg struct {
+	Content string
+	Err     error
+	Command bool // Opened by /editor rather than a key, so loading should stop
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+
+	"codeaid/theme"
+)
+
+// renderKey identifies the rendered form of a message: its text and kind,
+// the width it was wrapped to and the theme it was coloured with
+type renderKey struct {
+	width int
+	theme theme.Theme
+	kind  string
+	text  string
+}
+
+// renderedBlock is a message rendered for display, with its line count
+type renderedBlock struct {
+	text  string
+	lines int
+}
+
+// renderCache keeps rendered messages between frames so only new or
+// changed messages are wrapped and styled again. Entries not used for a
+// whole frame are dropped, so it never holds more than two frames.
+type renderCache struct {
+	current  map[renderKey]renderedBlock
+	previous map[renderKey]renderedBlock
+}
+
+// newRenderCache creates an empty render cache
+func newRenderCache() *renderCache {
+	return &renderCache{current: map[renderKey]renderedBlock{}}
+}
+
+// nextFrame starts a new frame; entries unused since the last one are
+// forgotten
+func (c *renderCache) nextFrame() {
+	c.previous = c.current
+	c.current = make(map[renderKey]renderedBlock, len(c.previous))
+}
+
+// get returns the cached block for key, rendering it on a miss
+func (c *renderCache) get(key renderKey, render func() string) renderedBlock {
+	if block, ok := c.current[key]; ok {
+		return block
+	}
+	block, ok := c.previous[key]
+	if !ok {
+		text := render()
+		block = renderedBlock{text: text, lines: strings.Count(text, "\n") + 1}
+	}
+	c.current[key] = block
+	return block
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"codeaid/cmds"
+	"codeaid/config"
+	"codeaid/theme"
+	"codeaid/utils"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Script timing limits
+const (
+	scriptIdleTimeout  = 10 * time.Second
+	scriptReplyTimeout = 5 * time.Second
+	scriptPollInterval = 10 * time.Millisecond
+)
+
+// ansiPattern matches terminal escape sequences, which are stripped from
+// snapshots so golden files are plain text
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+// latencyPattern matches the latency shown under a reply, which is
+// replaced in snapshots so a slow run still matches its golden file
+var latencyPattern = regexp.MustCompile(` · [0-9]+\.[0-9]s · `)
+
+// scriptConfig is the global configuration scripts run with. The status
+// line leaves out the directory and git branch, which depend on where the
+// script runs.
+const scriptConfig = `{"version": 1, "status_bar": ["model", "tokens", "cost", "state"]}`
+
+// scriptKeys maps key names used in scripts to key types
+var scriptKeys = map[string]tea.KeyType{
+	"enter":     tea.KeyEnter,
+	"tab":       tea.KeyTab,
+	"esc":       tea.KeyEsc,
+	"backspace": tea.KeyBackspace,
+	"delete":    tea.KeyDelete,
+	"up":        tea.KeyUp,
+	"down":      tea.KeyDown,
+	"left":      tea.KeyLeft,
+	"right":     tea.KeyRight,
+	"home":      tea.KeyHome,
+	"end":       tea.KeyEnd,
+	"space":     tea.KeySpace,
+	"pgup":      tea.KeyPgUp,
+	"pgdown":    tea.KeyPgDown,
+}
+
+// scriptModel wraps the model so the script can read its view and state
+// through the program's message loop
+type scriptModel struct {
+	model
+}
+
+// snapshotMsg asks for the current view
+type snapshotMsg struct {
+	reply chan string
+}
+
+// idleMsg asks whether the model has finished all requests
+type idleMsg struct {
+	reply chan bool
+}
+
+// Update answers script queries and passes everything else to the model
+func (s scriptModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case snapshotMsg:
+		msg.reply <- s.model.View()
+		return s, nil
+	case idleMsg:
+		msg.reply <- !s.model.loading && len(s.model.queue) == 0
+		return s, nil
+	}
+
+	updated, cmd := s.model.Update(msg)
+	return scriptModel{updated.(model)}, cmd
+}
+
+// scriptRun holds the state of a running script
+type scriptRun struct {
+	program   *tea.Program
+	done      chan struct{}
+	goldenDir string
+	update    bool
+	failures  int
+}
+
+// runScript drives the TUI headlessly from a script file and compares
+// snapshots with golden files. Replies come from the fake provider, and
+// the configuration is isolated from the user's.
+//
+// Each line of the script is one step; blank lines and lines starting
+// with # are ignored:
+//
+//	size 80 24            send a window size
+//	type hello world      type text, one key per character
+//	key tab down enter    press keys (ctrl+x and alt+x are also accepted)
+//	reply some text       queue the next fake reply
+//	reply-length text     queue a reply cut off at the token limit
+//	wait 200ms            pause
+//	wait idle             wait until no request is loading or queued
+//	snapshot name         compare the view with <script>.golden/name.txt
+//	blur, focus           report the terminal losing or regaining focus
+//
+// It returns the process exit code: 0 if every snapshot matched.
+func runScript(path string, update bool) int {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	// Run with a configuration of its own in a temporary directory, so
+	// neither the user's files nor the environment change the snapshots,
+	// and /config cannot overwrite the real file
+	configDir, err := os.MkdirTemp("", "codeaid-script-")
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(configDir)
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(scriptConfig), 0600); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	config.Isolate(configDir)
+	defer config.Isolate("")
+
+	utils.UseFakeProvider()
+	utils.ClearHistory()
+	// A fixed theme keeps runs independent of the terminal background
+	theme.Use("dark")
+	utils.SetCommandHandler(cmds.CommandRegistry{})
+
+	run := &scriptRun{
+		program: tea.NewProgram(
+			scriptModel{newModel()},
+			// An empty reader rather than no input, so the program can
+			// restore its input after running an external editor
+			tea.WithInput(strings.NewReader("")),
+			tea.WithOutput(io.Discard),
+			tea.WithoutRenderer(),
+			tea.WithoutSignalHandler(),
+		),
+		done:      make(chan struct{}),
+		goldenDir: strings.TrimSuffix(path, filepath.Ext(path)) + ".golden",
+		update:    update,
+	}
+	go func() {
+		run.program.Run()
+		close(run.done)
+	}()
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := run.step(line); err != nil {
+			fmt.Printf("%s:%d: %v\n", path, lineNumber, err)
+			run.program.Kill()
+			return 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	run.program.Quit()
+	<-run.done
+
+	if run.failures > 0 {
+		fmt.Printf("%d snapshot(s) did not match\n", run.failures)
+		return 1
+	}
+	return 0
+}
+
+// step runs a single script line
+func (r *scriptRun) step(line string) error {
+	command, arg, _ := strings.Cut(line, " ")
+
+	switch command {
+	case "size":
+		var width, height int
+		if _, err := fmt.Sscanf(arg, "%d %d", &width, &height); err != nil {
+			return fmt.Errorf("usage: size WIDTH HEIGHT")
+		}
+		r.program.Send(tea.WindowSizeMsg{Width: width, Height: height})
+
+	case "type":
+		for _, char := range arg {
+			if char == ' ' {
+				r.program.Send(tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}})
+			} else {
+				r.program.Send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{char}})
+			}
+		}
+
+	case "key":
+		for _, name := range strings.Fields(arg) {
+			key, err := parseScriptKey(name)
+			if err != nil {
+				return err
+			}
+			r.program.Send(key)
+		}
+
+	case "blur":
+		r.program.Send(tea.BlurMsg{})
+
+	case "focus":
+		r.program.Send(tea.FocusMsg{})
+
+	case "reply", "reply-length":
+		reply := utils.FakeReply{Content: arg}
+		if command == "reply-length" {
+			reply.FinishReason = "length"
+		}
+		utils.QueueFakeReply(reply)
+
+	case "wait":
+		if arg == "idle" {
+			return r.waitIdle()
+		}
+		duration, err := time.ParseDuration(arg)
+		if err != nil {
+			return fmt.Errorf("usage: wait DURATION|idle")
+		}
+		time.Sleep(duration)
+
+	case "snapshot":
+		if arg == "" {
+			return fmt.Errorf("usage: snapshot NAME")
+		}
+		view, err := r.snapshot()
+		if err != nil {
+			return err
+		}
+		return r.compare(arg, view)
+
+	default:
+		return fmt.Errorf("unknown step %q", command)
+	}
+	return nil
+}
+
+// parseScriptKey converts a key name such as "enter", "ctrl+c" or
+// "alt+left" to a key message
+func parseScriptKey(name string) (tea.KeyMsg, error) {
+	key := tea.KeyMsg{}
+	if rest, ok := strings.CutPrefix(name, "alt+"); ok {
+		key.Alt = true
+		name = rest
+	}
+
+	if keyType, ok := scriptKeys[name]; ok {
+		key.Type = keyType
+		return key, nil
+	}
+	if letter, ok := strings.CutPrefix(name, "ctrl+"); ok && len(letter) == 1 && letter[0] >= 'a' && letter[0] <= 'z' {
+		key.Type = tea.KeyCtrlA + tea.KeyType(letter[0]-'a')
+		return key, nil
+	}
+	if len([]rune(name)) == 1 {
+		key.Type = tea.KeyRunes
+		key.Runes = []rune(name)
+		return key, nil
+	}
+	return key, fmt.Errorf("unknown key %q", name)
+}
+
+// query sends a message to the model and waits for its reply
+func query[T any](r *scriptRun, msg func(chan T) tea.Msg) (T, error) {
+	reply := make(chan T, 1)
+	r.program.Send(msg(reply))
+
+	var zero T
+	select {
+	case value := <-reply:
+		return value, nil
+	case <-r.done:
+		return zero, fmt.Errorf("the program exited")
+	case <-time.After(scriptReplyTimeout):
+		return zero, fmt.Errorf("the program did not respond")
+	}
+}
+
+// waitIdle waits until no request is loading or queued
+func (r *scriptRun) waitIdle() error {
+	deadline := time.Now().Add(scriptIdleTimeout)
+	for time.Now().Before(deadline) {
+		idle, err := query(r, func(reply chan bool) tea.Msg { return idleMsg{reply} })
+		if err != nil {
+			return err
+		}
+		if idle {
+			return nil
+		}
+		time.Sleep(scriptPollInterval)
+	}
+	return fmt.Errorf("still loading after %s", scriptIdleTimeout)
+}
+
+// snapshot returns the current view as plain text with latencies zeroed
+// and trailing spaces removed from each line
+func (r *scriptRun) snapshot() (string, error) {
+	view, err := query(r, func(reply chan string) tea.Msg { return snapshotMsg{reply} })
+	if err != nil {
+		return "", err
+	}
+
+	view = latencyPattern.ReplaceAllString(ansiPattern.ReplaceAllString(view, ""), " · 0.0s · ")
+	lines := strings.Split(view, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n") + "\n", nil
+}
+
+// compare checks a snapshot against its golden file, or writes the
+// golden file when updating
+func (r *scriptRun) compare(name, view string) error {
+	goldenPath := filepath.Join(r.goldenDir, name+".txt")
+
+	if r.update {
+		if err := os.MkdirAll(r.goldenDir, 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(goldenPath, []byte(view), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("updated %s\n", goldenPath)
+		return nil
+	}
+
+	golden, err := os.ReadFile(goldenPath)
+	if err != nil {
+		return fmt.Errorf("snapshot %s: %v (run with --update-golden to create it)", name, err)
+	}
+	if string(golden) == view {
+		fmt.Printf("ok   %s\n", name)
+		return nil
+	}
+
+	r.failures++
+	fmt.Printf("FAIL %s\n%s", name, diffLines(string(golden), view))
+	return nil
+}
+
+// diffLines lists the lines that differ between the golden file and the
+// snapshot
+func diffLines(expected, actual string) string {
+	want := strings.Split(expected, "\n")
+	got := strings.Split(actual, "\n")
+
+	var sb strings.Builder
+	for i := 0; i < len(want) || i < len(got); i++ {
+		var w, g string
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if w != g {
+			sb.WriteString(fmt.Sprintf("  line %d:\n    - %s\n    + %s\n", i+1, w, g))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"codeaid/cmds"
+	"codeaid/conversation"
+	"codeaid/messages"
+	"codeaid/utils"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// outcome is the result of a chat message or command in stdio mode
+type outcome struct {
+	Output  string        `json:"output,omitempty"`  // Text the command showed
+	Turn    *TurnInfo     `json:"turn,omitempty"`    // Reply fetched by the request
+	Edit    *editInfo     `json:"edit,omitempty"`    // Message loaded for editing by /edit
+	Compare []compareInfo `json:"compare,omitempty"` // Answers to pick from after /compare
+	Cleared bool          `json:"cleared,omitempty"` // The conversation was cleared
+}
+
+// editInfo is a message the client should let the user edit; sending the
+// edited text with chat starts a new branch, as Enter does in the TUI
+type editInfo struct {
+	Index  int    `json:"index"`
+	Prompt string `json:"prompt"`
+}
+
+// compareInfo is one model's answer from /compare
+type compareInfo struct {
+	Model            string `json:"model"`
+	Reply            string `json:"reply,omitempty"`
+	Error            string `json:"error,omitempty"`
+	PromptTokens     int    `json:"prompt_tokens,omitempty"`
+	CompletionTokens int    `json:"completion_tokens,omitempty"`
+	LatencyMS        int64  `json:"latency_ms,omitempty"`
+}
+
+// comparison remembers the answers of the last /compare for
+// compare/choose
+type comparison struct {
+	turnID  int
+	results []messages.CompareResult
+}
+
+// addOutput appends command text to the outcome
+func (o *outcome) addOutput(text string) {
+	if o.Output != "" {
+		o.Output += "\n"
+	}
+	o.Output += text
+}
+
+// chat sends content to the model
+func (s *stdioServer) chat(ctx context.Context, content string) *outcome {
+	out := &outcome{}
+	s.announced = 0
+	s.run(ctx, utils.FetchReply(content), out)
+	return out
+}
+
+// command runs a slash command, asking the client first if it is a plugin
+func (s *stdioServer) command(ctx context.Context, input string) (*outcome, error) {
+	out := &outcome{}
+	name, _, _ := strings.Cut(input, " ")
+	if cmd, ok := cmds.LookupCommand(name); ok {
+		if terminal, ok := cmd.(cmds.TerminalCommand); ok && terminal.NeedsTerminal() {
+			out.Output = fmt.Sprintf("Error: %s needs the terminal interface", name)
+			return out, nil
+		}
+	}
+	if err := s.approve(ctx, input); err != nil {
+		out.Output = "Error: " + err.Error()
+		return out, nil
+	}
+	s.announced = 0
+	s.run(ctx, utils.ExecuteCommand(input), out)
+	return out, nil
+}
+
+// approve asks the client whether a plugin may run. Plugins are external
+// programs, so an editor embedding codeaid decides which ones run in its
+// workspace. Other commands need no approval.
+func (s *stdioServer) approve(ctx context.Context, input string) error {
+	name, args, _ := strings.Cut(input, " ")
+	cmd, ok := cmds.LookupCommand(name)
+	if !ok {
+		return nil
+	}
+	plugin, ok := cmd.(cmds.PluginCommand)
+	if !ok {
+		return nil
+	}
+
+	var answer struct {
+		Approved bool `json:"approved"`
+	}
+	err := s.call(ctx, "approval/request", map[string]string{
+		"tool":        "plugin",
+		"command":     plugin.Name(),
+		"args":        strings.TrimSpace(args),
+		"path":        plugin.Path(),
+		"description": plugin.Description(),
+	}, &answer)
+	if err != nil {
+		return fmt.Errorf("%s was not approved: %v", plugin.Name(), err)
+	}
+	if !answer.Approved {
+		return fmt.Errorf("%s was not approved", plugin.Name())
+	}
+	return nil
+}
+
+// choose continues the conversation from an answer of the last /compare,
+// like pressing its number in the TUI
+func (s *stdioServer) choose(index int) (*outcome, error) {
+	if s.comparison == nil {
+		return nil, &rpcError{codeInvalidParams, "there is no comparison to choose from; run /compare first"}
+	}
+	if index < 1 || index > len(s.comparison.results) {
+		return nil, &rpcError{codeInvalidParams, fmt.Sprintf("index must be between 1 and %d", len(s.comparison.results))}
+	}
+	result := s.comparison.results[index-1]
+	if result.Error != "" {
+		return nil, &rpcError{codeInvalidParams, fmt.Sprintf("answer %d failed: %s", index, result.Error)}
+	}
+
+	if err := utils.ContinueWith(s.comparison.turnID, result); err != nil {
+		return nil, err
+	}
+	s.comparison = nil
+	text := "Continuing with the answer from " + result.Model
+	utils.AddNote(text)
+
+	out := &outcome{Output: text}
+	if turn, ok := lastChat(); ok {
+		info := turnInfo(turn)
+		out.Turn = &info
+	}
+	return out, nil
+}
+
+// run executes a command's tea.Cmd and applies the resulting message the
+// way the TUI's Update does, following up with further requests where the
+// TUI would
+func (s *stdioServer) run(ctx context.Context, cmd tea.Cmd, out *outcome) {
+	if cmd == nil || ctx.Err() != nil {
+		return
+	}
+	s.announce()
+
+	switch msg := cmd().(type) {
+	case nil:
+		// Nothing to report
+
+	case tea.BatchMsg:
+		for _, next := range msg {
+			s.run(ctx, next, out)
+		}
+
+	case messages.ResponseMsg:
+		utils.EndRequest(msg.RequestID)
+		s.finish(out)
+
+	case messages.CancelMsg:
+		utils.EndRequest(msg.RequestID)
+		s.finish(out)
+
+	case messages.CommandResponseMsg:
+		utils.AddNote(string(msg))
+		out.addOutput(string(msg))
+
+	case messages.PluginMsg:
+		if msg.Display != "" {
+			utils.AddNote(msg.Display)
+			out.addOutput(msg.Display)
+		}
+		if msg.Prompt != "" {
+			s.run(ctx, utils.FetchReply(msg.Prompt), out)
+		}
+
+	case messages.ProfileMsg:
+		content := fmt.Sprintf("Using top-level settings (model %s)", msg.Model)
+		if msg.Name != "" {
+			content = fmt.Sprintf("Switched to profile %s (model %s)", msg.Name, msg.Model)
+		}
+		utils.AddNote(content)
+		out.addOutput(content)
+
+	case messages.HelpMsg:
+		content := helpText(msg)
+		utils.AddNote(content)
+		out.addOutput(content)
+
+	case messages.HistoryChangedMsg:
+		if msg.Fetch {
+			s.run(ctx, utils.FetchPending(), out)
+		}
+
+	case messages.EditMsg:
+		out.Edit = &editInfo{Index: msg.Index, Prompt: msg.Prompt}
+
+	case messages.ClearHistoryMsg:
+		out.Cleared = true
+
+	case messages.ComparePickMsg:
+		picked, err := s.choose(msg.Index)
+		if err != nil {
+			out.addOutput("Error: " + err.Error())
+			return
+		}
+		out.addOutput(picked.Output)
+		out.Turn = picked.Turn
+
+	case messages.CompareMsg:
+		if !utils.EndRequest(msg.RequestID) {
+			return
+		}
+		s.comparison = &comparison{turnID: msg.TurnID, results: msg.Results}
+		for _, result := range msg.Results {
+			out.Compare = append(out.Compare, compareInfo{
+				Model:            result.Model,
+				Reply:            result.Reply,
+				Error:            result.Error,
+				PromptTokens:     result.PromptTokens,
+				CompletionTokens: result.CompletionTokens,
+				LatencyMS:        result.Latency.Milliseconds(),
+			})
+		}
+
+	case messages.ConfigMsg:
+		out.addOutput("Error: interactive configuration is not available in stdio mode; run codeaid --config")
+
+	case tea.QuitMsg:
+		s.mu.Lock()
+		s.quit = true
+		s.mu.Unlock()
+
+	default:
+		// Messages only the TUI understands
+		out.addOutput("Error: this command needs the terminal interface")
+	}
+}
+
+// announce sends the pending turn a request was started for, once
+func (s *stdioServer) announce() {
+	turn, ok := lastChat()
+	if !ok || turn.State != conversation.StatePending || turn.ID == s.announced {
+		return
+	}
+	s.announced = turn.ID
+	s.notify("turn", turnInfo(turn))
+}
+
+// finish reports the turn a request finished, as a notification and in
+// the outcome
+func (s *stdioServer) finish(out *outcome) {
+	turn, ok := findTurn(s.announced)
+	if !ok {
+		// Requests such as /continue extend a completed turn
+		turn, ok = lastChat()
+	}
+	if !ok {
+		return
+	}
+	info := turnInfo(turn)
+	out.Turn = &info
+	s.notify("turn", info)
+}
+
+// lastChat returns the last chat turn on the active path
+func lastChat() (conversation.Turn, bool) {
+	path := utils.ConversationPath()
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i].Kind == conversation.KindChat {
+			return path[i], true
+		}
+	}
+	return conversation.Turn{}, false
+}
+
+// findTurn returns the turn with the given ID on the active path
+func findTurn(id int) (conversation.Turn, bool) {
+	if id == 0 {
+		return conversation.Turn{}, false
+	}
+	for _, turn := range utils.ConversationPath() {
+		if turn.ID == id {
+			return turn, true
+		}
+	}
+	return conversation.Turn{}, false
+}
+
+// conversationTurns describes the active path, including command notes
+// but not messages injected by plugins
+func conversationTurns() []TurnInfo {
+	turns := []TurnInfo{}
+	for _, turn := range utils.ConversationPath() {
+		if turn.Kind != conversation.KindInjected {
+			turns = append(turns, turnInfo(turn))
+		}
+	}
+	return turns
+}
+
+// helpText renders /help output as plain text
+func helpText(msg messages.HelpMsg) string {
+	var sb strings.Builder
+	sb.WriteString(msg.Header + "\n")
+	if msg.Usage != "" {
+		sb.WriteString("Usage: " + msg.Usage + "\n")
+	}
+	if len(msg.Examples) > 0 {
+		sb.WriteString("Examples:\n")
+		for _, example := range msg.Examples {
+			sb.WriteString("  " + example + "\n")
+		}
+	}
+	if msg.Usage != "" && len(msg.Commands) > 0 {
+		sb.WriteString("Subcommands:\n")
+	}
+	for _, cmd := range msg.Commands {
+		sb.WriteString(cmd.Name + " - " + cmd.Description + "\n")
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
@@ -0,0 +1,9 @@
+package server
+
+import _ "embed"
+
+// openAPI describes the API in OpenAPI 3 format; keep it in step with
+// routes
+//
+//go:embed openapi.json
+var openAPI []byte
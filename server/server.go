@@ -0,0 +1,324 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"codeaid/config"
+	"codeaid/conversation"
+	"codeaid/utils"
+)
+
+// DefaultAddr is where the server listens when no address is given
+const DefaultAddr = "127.0.0.1:8765"
+
+// keepAlive is how often an idle event stream sends a comment, so proxies
+// and clients do not drop it while the model is thinking
+const keepAlive = 15 * time.Second
+
+// GetTokenPath returns the path of the file holding the server's bearer
+// token in the config directory
+func GetTokenPath() (string, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "serve-token"), nil
+}
+
+// LoadToken returns the bearer token clients must send, creating the
+// token file on first use. The token is kept across restarts so that
+// editor plugins only need to read it once.
+func LoadToken() (string, error) {
+	path, err := GetTokenPath()
+	if err != nil {
+		return "", err
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := config.WriteFileAtomic(path, []byte(token+"\n")); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// checkLoopback refuses addresses other machines could reach; the API
+// runs requests with the user's key and must stay local
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%s is not a loopback address; the server only listens on localhost", addr)
+}
+
+// Serve runs the API server on addr until interrupted
+func Serve(addr string) error {
+	if err := checkLoopback(addr); err != nil {
+		return err
+	}
+	token, err := LoadToken()
+	if err != nil {
+		return fmt.Errorf("creating the API token: %v", err)
+	}
+	tokenPath, _ := GetTokenPath()
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	api := &server{store: newStore(), token: token}
+	httpServer := &http.Server{Handler: api.routes(), ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		api.store.cancelAll()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdown)
+	}()
+
+	fmt.Printf("Serving the codeaid API on http://%s\n", listener.Addr())
+	fmt.Printf("Bearer token: %s\n", tokenPath)
+	fmt.Printf("API description: http://%s/openapi.json\n", listener.Addr())
+
+	if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// server handles API requests
+type server struct {
+	store *store
+	token string
+}
+
+// routes registers the API endpoints. Everything except the API
+// description needs the bearer token.
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /openapi.json", s.handleOpenAPI)
+	mux.Handle("GET /v1/sessions", s.authorized(s.handleListSessions))
+	mux.Handle("POST /v1/sessions", s.authorized(s.handleCreateSession))
+	mux.Handle("GET /v1/sessions/{id}", s.authorized(s.handleGetSession))
+	mux.Handle("POST /v1/sessions/{id}/messages", s.authorized(s.handleSendMessage))
+	mux.Handle("POST /v1/sessions/{id}/cancel", s.authorized(s.handleCancel))
+	return mux
+}
+
+// authorized wraps a handler with the bearer token check
+func (s *server) authorized(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="codeaid"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		next(w, r)
+	})
+}
+
+// handleOpenAPI serves the API description
+func (s *server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPI)
+}
+
+// handleListSessions lists the sessions
+func (s *server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"sessions": s.store.list()})
+}
+
+// handleCreateSession creates a session; the body and its name are
+// optional
+func (s *server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, s.store.create(body.Name))
+}
+
+// handleGetSession returns a session with its messages and replies
+func (s *server) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	info, turns, err := s.store.get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"session": info, "turns": turns})
+}
+
+// handleSendMessage sends a message and returns the reply, either as one
+// JSON response or, when the client accepts text/event-stream, as
+// server-sent events carrying the reply as it arrives. The request keeps
+// running if the client goes away, and its outcome is stored on the
+// session; use cancel to stop it.
+func (s *server) handleSendMessage(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Content string `json:"content"`
+	}
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if strings.TrimSpace(body.Content) == "" {
+		writeError(w, http.StatusBadRequest, errors.New("content is empty"))
+		return
+	}
+
+	id := r.PathValue("id")
+	tree, turn, ctx, err := s.store.begin(id, body.Content)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+
+	// Pieces of the reply are handed to the event stream one at a time,
+	// so all of them are written before the finished turn
+	events := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	deltas := make(chan string)
+	var onDelta func(string)
+	if events {
+		onDelta = func(delta string) {
+			select {
+			case deltas <- delta:
+			case <-r.Context().Done():
+			}
+		}
+	}
+
+	done := make(chan conversation.Turn, 1)
+	go func() {
+		finished, _ := utils.CompleteTurn(ctx, tree, turn.ID, onDelta)
+		// Free the session before answering, so the client can send its
+		// next message as soon as it has the reply
+		s.store.finish(id, turn.ID)
+		done <- finished
+	}()
+
+	if !events {
+		writeJSON(w, http.StatusOK, turnInfo(<-done))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	writeEvent(w, "turn", turnInfo(turn))
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case delta := <-deltas:
+			writeEvent(w, "delta", DeltaInfo{ID: turn.ID, Content: delta})
+		case finished := <-done:
+			writeEvent(w, finished.State.String(), turnInfo(finished))
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flush(w)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleCancel cancels a session's request in flight
+func (s *server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.cancel(r.PathValue("id")); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// statusFor maps store errors to HTTP status codes
+func statusFor(err error) int {
+	switch err {
+	case ErrNoSession:
+		return http.StatusNotFound
+	case ErrBusy, ErrIdle:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// readJSON decodes a request body, allowing it to be empty
+func readJSON(r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	return nil
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error response as {"error": "..."}
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeEvent writes one server-sent event with a JSON payload
+func writeEvent(w http.ResponseWriter, event string, v any) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	flush(w)
+}
+
+// flush sends buffered output to the client
+func flush(w http.ResponseWriter) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
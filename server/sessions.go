@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"codeaid/conversation"
+)
+
+// Errors returned by the session store
+var (
+	ErrNoSession = errors.New("no such session")
+	ErrBusy      = errors.New("the session is already waiting for a reply")
+	ErrIdle      = errors.New("the session has no request in flight")
+)
+
+// Session is a conversation held by the server. Each session has its own
+// conversation tree and at most one request in flight.
+type Session struct {
+	ID      string
+	Name    string
+	Created time.Time
+
+	tree   *conversation.Tree
+	turnID int                // Pending turn, or 0 when idle
+	cancel context.CancelFunc // Cancels the pending turn's request
+}
+
+// store holds the sessions of a running server
+type store struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	nextID   int
+}
+
+// newStore creates an empty session store
+func newStore() *store {
+	return &store{sessions: map[string]*Session{}, nextID: 1}
+}
+
+// create adds a new session with an optional name
+func (s *store) create(name string) SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := &Session{
+		ID:      fmt.Sprintf("s%d", s.nextID),
+		Name:    name,
+		Created: time.Now().UTC(),
+		tree:    conversation.NewTree(),
+	}
+	s.nextID++
+	s.sessions[session.ID] = session
+	return session.info()
+}
+
+// list describes every session, oldest first
+func (s *store) list() []SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	infos := []SessionInfo{}
+	for _, session := range s.sessions {
+		infos = append(infos, session.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Created.Before(infos[j].Created) ||
+			infos[i].Created.Equal(infos[j].Created) && infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+// get describes one session together with its turns
+func (s *store) get(id string) (SessionInfo, []TurnInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return SessionInfo{}, nil, ErrNoSession
+	}
+	turns := []TurnInfo{}
+	for _, turn := range session.tree.Path() {
+		if turn.Kind == conversation.KindChat {
+			turns = append(turns, turnInfo(turn))
+		}
+	}
+	return session.info(), turns, nil
+}
+
+// begin adds a pending turn for prompt to a session and returns the tree,
+// the turn and a context that is cancelled by cancel or shutdown
+func (s *store) begin(id, prompt string) (*conversation.Tree, conversation.Turn, context.Context, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, conversation.Turn{}, nil, ErrNoSession
+	}
+	if session.turnID != 0 {
+		return nil, conversation.Turn{}, nil, ErrBusy
+	}
+
+	turn := session.tree.Begin(prompt, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	session.turnID = turn.ID
+	session.cancel = cancel
+	return session.tree, turn, ctx, nil
+}
+
+// finish marks a session idle once its pending turn has an outcome
+func (s *store) finish(id string, turnID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.turnID != turnID {
+		return
+	}
+	session.cancel()
+	session.turnID = 0
+	session.cancel = nil
+}
+
+// cancel cancels the request in flight for a session
+func (s *store) cancel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return ErrNoSession
+	}
+	if session.turnID == 0 {
+		return ErrIdle
+	}
+	session.cancel()
+	return nil
+}
+
+// cancelAll cancels every request in flight, for shutdown
+func (s *store) cancelAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, session := range s.sessions {
+		if session.cancel != nil {
+			session.cancel()
+		}
+	}
+}
+
+// info describes a session; the store's lock must be held
+func (s *Session) info() SessionInfo {
+	return SessionInfo{
+		ID:       s.ID,
+		Name:     s.Name,
+		Created:  s.Created,
+		Messages: s.tree.PromptCount(),
+		Busy:     s.turnID != 0,
+	}
+}
+
+// SessionInfo describes a session in API responses
+type SessionInfo struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name,omitempty"`
+	Created  time.Time `json:"created"`
+	Messages int       `json:"messages"`
+	Busy     bool      `json:"busy"`
+}
+
+// TurnInfo describes a message and its reply in API responses
+type TurnInfo struct {
+	ID               int    `json:"id"`
+	Kind             string `json:"kind"`
+	State            string `json:"state"`
+	Prompt           string `json:"prompt"`
+	Reply            string `json:"reply,omitempty"`
+	Error            string `json:"error,omitempty"`
+	Model            string `json:"model,omitempty"`
+	PromptTokens     int    `json:"prompt_tokens,omitempty"`
+	CompletionTokens int    `json:"completion_tokens,omitempty"`
+	LatencyMS        int64  `json:"latency_ms,omitempty"`
+	Truncated        bool   `json:"truncated,omitempty"`
+}
+
+// DeltaInfo is a piece of a reply as it arrives, in API responses
+type DeltaInfo struct {
+	ID      int    `json:"id"` // Turn the reply belongs to
+	Content string `json:"content"`
+}
+
+// turnInfo converts a conversation turn for API responses
+func turnInfo(turn conversation.Turn) TurnInfo {
+	return TurnInfo{
+		ID:               turn.ID,
+		Kind:             kindName(turn.Kind),
+		State:            turn.State.String(),
+		Prompt:           turn.Prompt,
+		Reply:            turn.Reply,
+		Error:            turn.Error,
+		Model:            turn.Meta.Model,
+		PromptTokens:     turn.Meta.PromptTokens,
+		CompletionTokens: turn.Meta.CompletionTokens,
+		LatencyMS:        turn.Meta.Latency.Milliseconds(),
+		Truncated:        turn.Meta.Truncated(),
+	}
+}
+
+// kindName names a turn kind for API responses
+func kindName(kind conversation.Kind) string {
+	switch kind {
+	case conversation.KindNote:
+		return "note"
+	case conversation.KindInjected:
+		return "injected"
+	}
+	return "chat"
+}
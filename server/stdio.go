@@ -0,0 +1,607 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"codeaid/cmds"
+	"codeaid/utils"
+)
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+	codeCancelled      = -32800
+)
+
+// rpcMessage is a JSON-RPC 2.0 request, notification or response
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is the error object of a JSON-RPC response
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error returns the error message
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// stdioServer speaks JSON-RPC 2.0 for editor integrations. It drives the
+// same conversation as the terminal interface, so slash commands from the
+// registry work on it as they do there. Chat messages and commands run
+// one at a time in the order they arrive.
+//
+// Messages are read one JSON document per line, or framed with
+// Content-Length headers as in the Language Server Protocol; replies use
+// the framing of the first message received.
+//
+// Client to server methods:
+//
+//	initialize            -> {name, model, profile, commands}
+//	chat {content}        -> outcome; sends the content to the model
+//	command/execute {input}
+//	                      -> outcome; runs a slash command such as "/retry"
+//	command/list          -> {commands: [{name, description}]}
+//	conversation/get      -> {turns}
+//	compare/choose {index}
+//	                      -> continues from an answer of the last /compare
+//	cancel                -> {cancelled}; cancels the running and queued
+//	                      messages and commands
+//	$/cancelRequest {id}  -> notification; cancels the request with that ID
+//
+// An outcome has any of output (command text), turn (the reply), edit
+// (a message loaded for editing), compare (answers to choose from) and
+// cleared. A request cancelled while it runs still answers with an
+// outcome whose turn is cancelled; one cancelled while queued fails with
+// code -32800.
+//
+// Server to client:
+//
+//	turn                  notification with a turn when its request starts
+//	                      (state pending) and when it finishes
+//	turn/delta {id, content}
+//	                      notification with a piece of a turn's reply as it
+//	                      arrives; /continue adds to a completed reply
+//	approval/request {tool, command, args, path, description}
+//	                      request answered with {approved}; sent before a
+//	                      plugin runs. Errors and missing answers deny it.
+type stdioServer struct {
+	reader *bufio.Reader
+	out    io.Writer
+
+	writeMux sync.Mutex
+	headers  bool // Frame output with Content-Length headers
+	framed   bool // The framing has been chosen
+
+	mu         sync.Mutex
+	queue      []*job // Chat messages and commands waiting to run
+	current    *job   // The running one
+	working    bool   // A goroutine is running the queue
+	nextCallID int
+	calls      map[string]chan rpcMessage // Our requests waiting for answers
+	quit       bool                       // /exit was run
+	done       chan struct{}              // Closed to stop the server
+	stop       sync.Once
+
+	// Used by one chat or command at a time
+	announced  int         // Pending turn already sent as a notification
+	comparison *comparison // Answers of the last /compare
+}
+
+// ServeStdio serves JSON-RPC on in and out until in is closed or /exit
+// is run
+func ServeStdio(in io.Reader, out io.Writer) error {
+	s := &stdioServer{
+		reader: bufio.NewReader(in),
+		out:    out,
+		calls:  map[string]chan rpcMessage{},
+		done:   make(chan struct{}),
+	}
+	// Pass replies on as they arrive
+	utils.SetStreamHandler(func(turnID int, delta string) {
+		s.notify("turn/delta", DeltaInfo{ID: turnID, Content: delta})
+	})
+	defer utils.SetStreamHandler(nil)
+
+	// Read in the background so /exit can stop the server while it
+	// waits for input
+	incoming := make(chan []byte)
+	failed := make(chan error, 1)
+	go func() {
+		for {
+			data, err := s.read()
+			if err != nil {
+				failed <- err
+				return
+			}
+			incoming <- data
+		}
+	}()
+
+	// Stop whatever is running before waiting for the handlers
+	var handlers sync.WaitGroup
+	defer handlers.Wait()
+	defer s.cancel("")
+	for {
+		select {
+		case data := <-incoming:
+			s.receive(data, &handlers)
+		case err := <-failed:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-s.done:
+			return nil
+		}
+	}
+}
+
+// receive handles one message from the client. Requests are started in
+// the order they arrive and answered from their own goroutines, so that
+// cancellations and answers to our requests are read while they run.
+func (s *stdioServer) receive(data []byte, handlers *sync.WaitGroup) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		s.receiveBatch(data, handlers)
+		return
+	}
+
+	var msg rpcMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		s.write(errorResponse(nil, codeParseError, err.Error()))
+		return
+	}
+	if msg.Method == "" && msg.ID != nil && (msg.Result != nil || msg.Error != nil) {
+		s.answerCall(msg)
+		return
+	}
+	respond := s.start(msg)
+	handlers.Add(1)
+	go func() {
+		defer handlers.Done()
+		if response := respond(); response != nil {
+			s.write(response)
+		}
+		s.stopIfQuit()
+	}()
+}
+
+// stopIfQuit stops the server once /exit has been answered
+func (s *stdioServer) stopIfQuit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.quit {
+		s.stop.Do(func() { close(s.done) })
+	}
+}
+
+// read returns the next message, either a line or a Content-Length frame
+func (s *stdioServer) read() ([]byte, error) {
+	for {
+		line, err := s.reader.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(bytes.TrimSpace(line)) == 0) {
+			return nil, err
+		}
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 {
+			continue
+		}
+
+		name, value, found := strings.Cut(string(trimmed), ":")
+		if !found || !strings.EqualFold(name, "Content-Length") {
+			s.chooseFraming(false)
+			return trimmed, nil
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("invalid Content-Length header %q", trimmed)
+		}
+		// Skip any other headers up to the blank line
+		for {
+			header, err := s.reader.ReadBytes('\n')
+			if err != nil {
+				return nil, err
+			}
+			if len(bytes.TrimSpace(header)) == 0 {
+				break
+			}
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(s.reader, body); err != nil {
+			return nil, err
+		}
+		s.chooseFraming(true)
+		return body, nil
+	}
+}
+
+// chooseFraming sets the output framing from the first message
+func (s *stdioServer) chooseFraming(headers bool) {
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
+	if !s.framed {
+		s.headers = headers
+		s.framed = true
+	}
+}
+
+// write sends a message or batch of messages to the client
+func (s *stdioServer) write(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(errorResponse(nil, codeInternalError, err.Error()))
+	}
+
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
+	if s.headers {
+		fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+		return
+	}
+	fmt.Fprintf(s.out, "%s\n", data)
+}
+
+// notify sends a notification to the client
+func (s *stdioServer) notify(method string, params any) {
+	data, _ := json.Marshal(params)
+	s.write(rpcMessage{JSONRPC: "2.0", Method: method, Params: data})
+}
+
+// call sends a request to the client and waits for its answer
+func (s *stdioServer) call(ctx context.Context, method string, params any, result any) error {
+	s.mu.Lock()
+	id := fmt.Sprintf("codeaid-%d", s.nextCallID+1)
+	s.nextCallID++
+	answer := make(chan rpcMessage, 1)
+	s.calls[id] = answer
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.calls, id)
+		s.mu.Unlock()
+	}()
+
+	data, _ := json.Marshal(params)
+	s.write(rpcMessage{JSONRPC: "2.0", ID: json.RawMessage(strconv.Quote(id)), Method: method, Params: data})
+
+	select {
+	case msg := <-answer:
+		if msg.Error != nil {
+			return msg.Error
+		}
+		return json.Unmarshal(msg.Result, result)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// answerCall passes a client's response to the request waiting for it.
+// A duplicate answer is dropped rather than waiting for a reader.
+func (s *stdioServer) answerCall(msg rpcMessage) {
+	var id string
+	if json.Unmarshal(msg.ID, &id) != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if answer, ok := s.calls[id]; ok {
+		select {
+		case answer <- msg:
+		default:
+		}
+	}
+}
+
+// receiveBatch handles a batch of requests and answers with one array
+func (s *stdioServer) receiveBatch(data []byte, handlers *sync.WaitGroup) {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(data, &batch); err != nil {
+		s.write(errorResponse(nil, codeParseError, err.Error()))
+		return
+	}
+	if len(batch) == 0 {
+		s.write(errorResponse(nil, codeInvalidRequest, "empty batch"))
+		return
+	}
+
+	responders := make([]func() *rpcMessage, len(batch))
+	for i, raw := range batch {
+		var msg rpcMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			response := errorResponse(nil, codeInvalidRequest, err.Error())
+			responders[i] = func() *rpcMessage { return response }
+			continue
+		}
+		responders[i] = s.start(msg)
+	}
+
+	handlers.Add(1)
+	go func() {
+		defer handlers.Done()
+		responses := make([]*rpcMessage, len(responders))
+		var wait sync.WaitGroup
+		for i, respond := range responders {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				responses[i] = respond()
+			}()
+		}
+		wait.Wait()
+
+		answered := []*rpcMessage{}
+		for _, response := range responses {
+			if response != nil {
+				answered = append(answered, response)
+			}
+		}
+		if len(answered) > 0 {
+			s.write(answered)
+		}
+		s.stopIfQuit()
+	}()
+}
+
+// start begins a request or notification and returns a function that
+// waits for its response, or returns nil for notifications
+func (s *stdioServer) start(msg rpcMessage) func() *rpcMessage {
+	if msg.JSONRPC != "2.0" || msg.Method == "" {
+		response := errorResponse(msg.ID, codeInvalidRequest, "not a JSON-RPC 2.0 request")
+		return func() *rpcMessage { return response }
+	}
+
+	wait := s.dispatch(msg)
+	return func() *rpcMessage {
+		result, err := wait()
+		if msg.ID == nil {
+			return nil
+		}
+		if err != nil {
+			var rpcErr *rpcError
+			if !errors.As(err, &rpcErr) {
+				rpcErr = &rpcError{Code: codeInternalError, Message: err.Error()}
+			}
+			return errorResponse(msg.ID, rpcErr.Code, rpcErr.Message)
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			return errorResponse(msg.ID, codeInternalError, err.Error())
+		}
+		return &rpcMessage{JSONRPC: "2.0", ID: msg.ID, Result: data}
+	}
+}
+
+// dispatch starts a method and returns a function waiting for its result.
+// Chat messages and commands are queued and run one at a time, in the
+// order they arrived, like input sent while the TUI waits for a reply;
+// other methods answer at once.
+func (s *stdioServer) dispatch(msg rpcMessage) func() (any, error) {
+	switch msg.Method {
+	case "initialize":
+		return answer(map[string]any{
+			"name":     "codeaid",
+			"model":    utils.GetModel(),
+			"profile":  utils.ActiveProfile(),
+			"commands": commandList(),
+		}, nil)
+
+	case "command/list":
+		return answer(map[string]any{"commands": commandList()}, nil)
+
+	case "conversation/get":
+		return answer(map[string]any{"turns": conversationTurns()}, nil)
+
+	case "chat":
+		var params struct {
+			Content string `json:"content"`
+		}
+		if err := decodeParams(msg.Params, &params); err != nil {
+			return answer(nil, err)
+		}
+		if strings.TrimSpace(params.Content) == "" {
+			return answer(nil, &rpcError{codeInvalidParams, "content is empty"})
+		}
+		return s.enqueue(msg.ID, func(ctx context.Context) (any, error) {
+			return s.chat(ctx, params.Content), nil
+		})
+
+	case "command/execute":
+		var params struct {
+			Input string `json:"input"`
+		}
+		if err := decodeParams(msg.Params, &params); err != nil {
+			return answer(nil, err)
+		}
+		input := strings.TrimSpace(params.Input)
+		if !strings.HasPrefix(input, "/") {
+			return answer(nil, &rpcError{codeInvalidParams, "input must be a slash command such as /help"})
+		}
+		return s.enqueue(msg.ID, func(ctx context.Context) (any, error) {
+			return s.command(ctx, input)
+		})
+
+	case "compare/choose":
+		var params struct {
+			Index int `json:"index"`
+		}
+		if err := decodeParams(msg.Params, &params); err != nil {
+			return answer(nil, err)
+		}
+		return s.enqueue(msg.ID, func(ctx context.Context) (any, error) {
+			return s.choose(params.Index)
+		})
+
+	case "cancel":
+		return answer(map[string]bool{"cancelled": s.cancel("")}, nil)
+
+	case "$/cancelRequest":
+		var params struct {
+			ID json.RawMessage `json:"id"`
+		}
+		if err := decodeParams(msg.Params, &params); err != nil {
+			return answer(nil, err)
+		}
+		// An empty ID would match notifications and cancel everything
+		id := compactID(params.ID)
+		if id == "" || id == "null" {
+			return answer(nil, &rpcError{codeInvalidParams, "id is required"})
+		}
+		return answer(map[string]bool{"cancelled": s.cancel(id)}, nil)
+	}
+	return answer(nil, &rpcError{codeMethodNotFound, fmt.Sprintf("unknown method %q", msg.Method)})
+}
+
+// answer returns a result that is already known
+func answer(result any, err error) func() (any, error) {
+	return func() (any, error) {
+		return result, err
+	}
+}
+
+// job is a queued chat message or command
+type job struct {
+	id     string // Request ID, for $/cancelRequest
+	ctx    context.Context
+	cancel context.CancelFunc
+	run    func(ctx context.Context) (any, error)
+
+	done   chan struct{}
+	result any
+	err    error
+}
+
+// enqueue adds a chat message or command to the queue and returns a
+// function waiting for its result
+func (s *stdioServer) enqueue(id json.RawMessage, run func(ctx context.Context) (any, error)) func() (any, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{id: compactID(id), ctx: ctx, cancel: cancel, run: run, done: make(chan struct{})}
+
+	s.mu.Lock()
+	s.queue = append(s.queue, j)
+	if !s.working {
+		s.working = true
+		go s.work()
+	}
+	s.mu.Unlock()
+
+	return func() (any, error) {
+		<-j.done
+		return j.result, j.err
+	}
+}
+
+// work runs queued jobs until the queue is empty
+func (s *stdioServer) work() {
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.working = false
+			s.mu.Unlock()
+			return
+		}
+		j := s.queue[0]
+		s.queue = s.queue[1:]
+		s.current = j
+		s.mu.Unlock()
+
+		if j.ctx.Err() != nil {
+			// Cancelled while it waited
+			j.err = &rpcError{codeCancelled, "request cancelled"}
+		} else {
+			j.result, j.err = j.run(j.ctx)
+		}
+
+		s.mu.Lock()
+		s.current = nil
+		s.mu.Unlock()
+		j.cancel()
+		close(j.done)
+	}
+}
+
+// cancel cancels the chat message or command with the given request ID,
+// or the running one and everything queued if id is empty. It reports
+// whether anything was cancelled.
+func (s *stdioServer) cancel(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cancelled := false
+	if s.current != nil && (id == "" || id == s.current.id) {
+		s.current.cancel()
+		utils.CancelCurrentRequest()
+		cancelled = true
+	}
+	for _, j := range s.queue {
+		if id == "" || id == j.id {
+			j.cancel()
+			cancelled = true
+		}
+	}
+	return cancelled
+}
+
+// decodeParams decodes request parameters, allowing them to be absent
+func decodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{codeInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+// compactID normalises a request ID for comparison
+func compactID(id json.RawMessage) string {
+	var buf bytes.Buffer
+	if json.Compact(&buf, id) != nil {
+		return string(id)
+	}
+	return buf.String()
+}
+
+// errorResponse builds an error response; id is null when unknown
+func errorResponse(id json.RawMessage, code int, message string) *rpcMessage {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &rpcMessage{JSONRPC: "2.0", ID: id, Error: &rpcError{code, message}}
+}
+
+// commandList describes the registered slash commands
+func commandList() []map[string]string {
+	list := []map[string]string{}
+	for _, cmd := range cmds.GetAllCommands() {
+		list = append(list, map[string]string{"name": cmd.Name(), "description": cmd.Description()})
+	}
+	return list
+}
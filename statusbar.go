@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"codeaid/utils"
+)
+
+// statusSeparator separates the items of the status line
+const statusSeparator = " │ "
+
+// statusDropOrder lists status items from the first to leave out when the
+// terminal is too narrow to the last
+var statusDropOrder = []string{"cwd", "session", "branch", "cost", "profile", "state", "tokens", "model"}
+
+// statusLine renders the status line for the given width, leaving out the
+// least useful items until it fits
+func (m model) statusLine(width int) string {
+	status := utils.CurrentStatus()
+	if slices.Contains(status.Items, "none") {
+		return ""
+	}
+
+	type item struct {
+		name string
+		text string
+	}
+	var items []item
+	for _, name := range status.Items {
+		if text := m.statusItem(name, status); text != "" {
+			items = append(items, item{name, text})
+		}
+	}
+
+	join := func() string {
+		texts := make([]string, len(items))
+		for i, it := range items {
+			texts[i] = it.text
+		}
+		return strings.Join(texts, statusSeparator)
+	}
+
+	line := join()
+	for _, name := range statusDropOrder {
+		if len([]rune(line)) <= width || len(items) <= 1 {
+			break
+		}
+		items = slices.DeleteFunc(items, func(it item) bool { return it.name == name })
+		line = join()
+	}
+
+	if runes := []rune(line); len(runes) > width && width > 1 {
+		line = string(runes[:width-1]) + "…"
+	}
+	return line
+}
+
+// statusItem formats one item of the status line, or returns "" when it
+// has nothing to show
+func (m model) statusItem(name string, status utils.Status) string {
+	switch name {
+	case "model":
+		return status.Model
+	case "profile":
+		if m.profile != "" {
+			return "profile: " + m.profile
+		}
+	case "session":
+		if status.Session != "" {
+			return "session: " + status.Session
+		}
+	case "tokens":
+		if status.ContextLimit > 0 {
+			return fmt.Sprintf("ctx %s/%s (%d%%)", formatTokens(status.ContextTokens), formatTokens(status.ContextLimit),
+				status.ContextTokens*100/status.ContextLimit)
+		}
+		if status.ContextTokens > 0 {
+			return "ctx " + formatTokens(status.ContextTokens)
+		}
+	case "cost":
+		if status.Cost == 0 && status.CostUnknown {
+			return "cost unknown"
+		}
+		cost := fmt.Sprintf("$%.4f", status.Cost)
+		if status.Cost >= 1 {
+			cost = fmt.Sprintf("$%.2f", status.Cost)
+		}
+		if status.CostUnknown {
+			cost += "+"
+		}
+		return cost
+	case "state":
+		return m.requestState()
+	case "branch":
+		if status.Branch != "" {
+			return "git:" + status.Branch
+		}
+	case "cwd":
+		return shortenPath(status.Dir)
+	}
+	return ""
+}
+
+// requestState describes what the app is doing
+func (m model) requestState() string {
+	state := "idle"
+	switch request := utils.CurrentRequest(); {
+	case request.State != utils.RequestIdle:
+		state = request.String()
+	case m.loading:
+		state = "running command"
+	case m.comparison != nil:
+		state = "choosing an answer"
+	case m.editIndex > 0:
+		state = fmt.Sprintf("editing message %d", m.editIndex)
+	}
+	if len(m.queue) > 0 {
+		state += fmt.Sprintf(", %d queued", len(m.queue))
+	}
+	return state
+}
+
+// formatTokens abbreviates a token count, such as 1.2k or 128k
+func formatTokens(tokens int) string {
+	switch {
+	case tokens < 1000:
+		return fmt.Sprint(tokens)
+	case tokens < 10000:
+		return fmt.Sprintf("%.1fk", float64(tokens)/1000)
+	case tokens < 1000000:
+		return fmt.Sprintf("%dk", tokens/1000)
+	}
+	return fmt.Sprintf("%.1fM", float64(tokens)/1000000)
+}
+
+// shortenPath replaces the home directory with ~
+func shortenPath(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return path
+	}
+	if path == home {
+		return "~"
+	}
+	if rest, ok := strings.CutPrefix(path, home+string(filepath.Separator)); ok {
+		return filepath.Join("~", rest)
+	}
+	return path
+}
@@ -0,0 +1,83 @@
+package theme
+
+import "github.com/charmbracelet/lipgloss"
+
+// Styles are the lipgloss styles built from a theme for one width
+type Styles struct {
+	User         lipgloss.Style
+	Reply        lipgloss.Style
+	Error        lipgloss.Style
+	Loading      lipgloss.Style
+	Input        lipgloss.Style
+	Active       lipgloss.Style
+	Hint         lipgloss.Style
+	HintSelected lipgloss.Style
+	Command      lipgloss.Style
+	Footer       lipgloss.Style
+	Truncated    lipgloss.Style
+	Cursor       lipgloss.Style
+	Chip         lipgloss.Style
+
+	// Help output
+	Heading     lipgloss.Style
+	CommandName lipgloss.Style
+	Description lipgloss.Style
+}
+
+// Styles builds the styles of the theme for content of the given width
+func (t Theme) Styles(width int) Styles {
+	text := lipgloss.NewStyle().Foreground(color(t.Text))
+	return Styles{
+		User:         lipgloss.NewStyle().Foreground(color(t.User)).Width(width),
+		Reply:        text.Width(width),
+		Error:        lipgloss.NewStyle().Foreground(color(t.Error)).Bold(true).Width(width),
+		Loading:      lipgloss.NewStyle().Foreground(color(t.Warning)),
+		Input:        text.BorderStyle(lipgloss.RoundedBorder()).BorderForeground(color(t.Border)).MarginBottom(1).Width(width),
+		Active:       text.BorderStyle(lipgloss.RoundedBorder()).BorderForeground(color(t.Active)).Width(width),
+		Hint:         lipgloss.NewStyle().Foreground(color(t.Muted)).Width(width),
+		HintSelected: lipgloss.NewStyle().Foreground(color(t.Accent)).Width(width),
+		Command:      lipgloss.NewStyle().Foreground(color(t.Muted)).PaddingLeft(4).Width(width),
+		Footer:       lipgloss.NewStyle().Foreground(color(t.Muted)).Width(width),
+		Truncated:    lipgloss.NewStyle().Foreground(color(t.Warning)).Bold(true).Width(width),
+		Cursor:       cursorStyle(t),
+		Chip:         lipgloss.NewStyle().Foreground(color(t.ChipText)).Background(color(t.ChipFill)).Padding(0, 1),
+
+		Heading:     lipgloss.NewStyle().Bold(true).Foreground(color(t.Heading)),
+		CommandName: lipgloss.NewStyle().Bold(true).Foreground(color(t.Command)),
+		Description: lipgloss.NewStyle().Foreground(color(t.Secondary)),
+	}
+}
+
+// cursorStyle highlights the character under the cursor; themes without a
+// cursor colour use reverse video so the cursor stays visible
+func cursorStyle(t Theme) lipgloss.Style {
+	if t.Cursor == "" {
+		return lipgloss.NewStyle().Reverse(true)
+	}
+	return lipgloss.NewStyle().Background(color(t.Cursor))
+}
+
+// Swatch renders a short sample of the theme's colours for previews
+func (t Theme) Swatch() string {
+	samples := []struct {
+		label string
+		value string
+	}{
+		{"user", t.User},
+		{"muted", t.Muted},
+		{"accent", t.Accent},
+		{"error", t.Error},
+		{"warning", t.Warning},
+		{"border", t.Active},
+		{"command", t.Command},
+	}
+
+	swatch := ""
+	for i, sample := range samples {
+		if i > 0 {
+			swatch += " "
+		}
+		swatch += lipgloss.NewStyle().Foreground(color(sample.value)).Render(sample.label)
+	}
+	return swatch
+}
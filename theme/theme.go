@@ -0,0 +1,218 @@
+package theme
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"codeaid/config"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Auto picks the dark or light theme from the terminal background
+const Auto = "auto"
+
+// Theme holds the colours used by the interface. Values are lipgloss
+// colours: ANSI numbers such as "8" or hex values such as "#7aa2f7".
+// Empty values use the terminal's default colour.
+type Theme struct {
+	Name string `json:"name"`
+
+	Text      string `json:"text"`      // Replies
+	User      string `json:"user"`      // The user's messages
+	Muted     string `json:"muted"`     // Hints, command output and footers
+	Accent    string `json:"accent"`    // Selected hint
+	Error     string `json:"error"`     // Errors
+	Warning   string `json:"warning"`   // Loading indicator and truncation
+	Border    string `json:"border"`    // Input box
+	Active    string `json:"active"`    // Input box while a request runs
+	Cursor    string `json:"cursor"`    // Cursor background
+	ChipText  string `json:"chip_text"` // Attachment chips
+	ChipFill  string `json:"chip_fill"` // Attachment chip background
+	Heading   string `json:"heading"`   // Help headings
+	Command   string `json:"command"`   // Command names in help
+	Secondary string `json:"secondary"` // Descriptions in help
+}
+
+// builtins are the themes that ship with codeaid
+var builtins = map[string]Theme{
+	"dark": {
+		Name: "dark", User: "8", Muted: "8", Accent: "14", Error: "9", Warning: "3",
+		Border: "8", Active: "12", Cursor: "7", ChipText: "15", ChipFill: "8",
+		Heading: "3", Command: "4", Secondary: "7",
+	},
+	"light": {
+		Name: "light", User: "8", Muted: "8", Accent: "6", Error: "1", Warning: "3",
+		Border: "8", Active: "4", Cursor: "8", ChipText: "15", ChipFill: "8",
+		Heading: "5", Command: "4", Secondary: "0",
+	},
+	"tokyo-night": {
+		Name: "tokyo-night", Text: "#c0caf5", User: "#565f89", Muted: "#565f89", Accent: "#7dcfff",
+		Error: "#f7768e", Warning: "#e0af68", Border: "#3b4261", Active: "#7aa2f7", Cursor: "#c0caf5",
+		ChipText: "#1a1b26", ChipFill: "#7aa2f7", Heading: "#bb9af7", Command: "#7aa2f7", Secondary: "#a9b1d6",
+	},
+	"mono": {Name: "mono"},
+}
+
+var (
+	current     Theme
+	currentName string
+	darkBG      *bool
+	themeMux    sync.Mutex
+)
+
+// GetThemeDir returns the directory searched for user theme files
+func GetThemeDir() (string, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "themes"), nil
+}
+
+// Names lists the built-in and user themes, sorted, with auto first
+func Names() []string {
+	seen := map[string]bool{}
+	for name := range builtins {
+		seen[name] = true
+	}
+	if dir, err := GetThemeDir(); err == nil {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if !entry.IsDir() && filepath.Ext(entry.Name()) == ".json" && !strings.HasPrefix(entry.Name(), ".") {
+				seen[strings.TrimSuffix(entry.Name(), ".json")] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{Auto}, names...)
+}
+
+// Load returns the named theme. User theme files in the themes directory
+// override built-in themes of the same name; fields they leave out are
+// taken from the theme matching the terminal background.
+func Load(name string) (Theme, error) {
+	if name == "" || name == Auto {
+		return autoTheme(), nil
+	}
+	// A name is a file in the themes directory, never a path out of it
+	if filepath.Base(name) != name || strings.HasPrefix(name, ".") {
+		return Theme{}, fmt.Errorf("invalid theme name %q", name)
+	}
+
+	if dir, err := GetThemeDir(); err == nil {
+		data, err := os.ReadFile(filepath.Join(dir, name+".json"))
+		if err == nil {
+			base, ok := builtins[name]
+			if !ok {
+				base = autoTheme()
+			}
+			var user Theme
+			if err := json.Unmarshal(data, &user); err != nil {
+				return Theme{}, fmt.Errorf("theme %s: %v", name, err)
+			}
+			merged := overlay(base, user)
+			merged.Name = name
+			return merged, nil
+		}
+		if !os.IsNotExist(err) {
+			return Theme{}, err
+		}
+	}
+
+	theme, ok := builtins[name]
+	if !ok {
+		return Theme{}, fmt.Errorf("unknown theme %q", name)
+	}
+	return theme, nil
+}
+
+// Use makes the named theme current
+func Use(name string) error {
+	theme, err := Load(name)
+	if err != nil {
+		return err
+	}
+
+	themeMux.Lock()
+	defer themeMux.Unlock()
+
+	current = theme
+	currentName = name
+	if currentName == "" {
+		currentName = Auto
+	}
+	return nil
+}
+
+// Current returns the current theme, the automatic one if none was chosen
+func Current() Theme {
+	themeMux.Lock()
+	if currentName != "" {
+		defer themeMux.Unlock()
+		return current
+	}
+	themeMux.Unlock()
+
+	_ = Use(Auto)
+	return Current()
+}
+
+// CurrentName returns the name the current theme was chosen by
+func CurrentName() string {
+	Current()
+
+	themeMux.Lock()
+	defer themeMux.Unlock()
+
+	return currentName
+}
+
+// autoTheme returns the dark or light theme for the terminal background,
+// which is detected once
+func autoTheme() Theme {
+	themeMux.Lock()
+	if darkBG == nil {
+		dark := lipgloss.HasDarkBackground()
+		darkBG = &dark
+	}
+	dark := *darkBG
+	themeMux.Unlock()
+
+	if dark {
+		return builtins["dark"]
+	}
+	return builtins["light"]
+}
+
+// overlay returns base with every non-empty field of top applied
+func overlay(base, top Theme) Theme {
+	merged := base
+	target := reflect.ValueOf(&merged).Elem()
+	source := reflect.ValueOf(top)
+	for i := 0; i < source.NumField(); i++ {
+		if value := source.Field(i).String(); value != "" {
+			target.Field(i).SetString(value)
+		}
+	}
+	return merged
+}
+
+// color converts a theme value to a lipgloss colour
+func color(value string) lipgloss.TerminalColor {
+	if value == "" {
+		return lipgloss.NoColor{}
+	}
+	return lipgloss.Color(value)
+}
@@ -2,15 +2,17 @@ package utils
 
 import (
 	"codeaid/config"
+	"codeaid/conversation"
 	"codeaid/messages"
 	"context"
-	"os"
+	"fmt"
+	"math"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/charmbracelet/bubbletea"
-	"github.com/joho/godotenv"
 	openai "github.com/sashabaranov/go-openai"
 )
 
@@ -32,53 +34,115 @@ func SetCommandHandler(handler CommandHandler) {
 	commandHandler = handler
 }
 
-// Global variable to hold cancellation function
-var currentCancelFunc context.CancelFunc
+// request is an API request in flight
+type request struct {
+	id      int
+	turnID  int
+	cancel  context.CancelFunc
+	state   RequestState
+	attempt int
+}
+
+// Request tracking. Only one request is active at a time; responses carry
+// their request ID so the UI can drop those that arrive after their
+// request was cancelled or replaced.
+var (
+	activeRequest *request
+	nextRequestID = 1
+	requestMux    sync.Mutex
+)
 
 // Global client and environment setup to avoid repeated initialization
 var (
 	apiClient           *openai.Client
-	apiKey              string
+	clientSettings      config.Settings
 	clientInitMux       sync.Mutex
 	clientInitialized   bool
-	conversationMux     sync.Mutex
-	conversationHistory []openai.ChatCompletionMessage
+	conversationTree    = conversation.NewTree()
 )
 
-// Initialize API client once
-func initClient() *openai.Client {
+// currentSettings resolves the effective connection settings, including
+// the active profile
+func currentSettings() (config.Settings, error) {
+	resolved, err := config.Resolve()
+	if err != nil {
+		return config.Settings{}, err
+	}
+	return resolved.Settings()
+}
+
+// requestTemperature returns the temperature to send. The client omits a
+// zero temperature, which would leave the provider's default in place, so
+// 0 is sent as the smallest positive value instead.
+func requestTemperature(temperature float32) float32 {
+	if temperature == 0 {
+		return math.SmallestNonzeroFloat32
+	}
+	return temperature
+}
+
+// Initialize API client, rebuilding it when the key, provider, base URL,
+// HTTP or debug settings change (for example after switching profiles)
+func initClient() (*openai.Client, config.Settings, error) {
 	clientInitMux.Lock()
 	defer clientInitMux.Unlock()
 
-	if !clientInitialized {
-		// First try to load from config file
-		cfg, err := config.Load()
-		if err == nil && cfg != nil && cfg.OpenRouterAPIKey != "" {
-			apiKey = cfg.OpenRouterAPIKey
-		} else {
-			// Fall back to .env file if config doesn't exist or doesn't have a key
-			_ = godotenv.Load()
-			apiKey = os.Getenv("OPENROUTER_API_KEY")
+	settings, err := currentSettings()
+	if err != nil {
+		return nil, settings, err
+	}
+
+	changed := settings.APIKey != clientSettings.APIKey ||
+		settings.BaseURL != clientSettings.BaseURL ||
+		settings.Provider != clientSettings.Provider ||
+		settings.Debug != clientSettings.Debug ||
+		!reflect.DeepEqual(settings.HTTP, clientSettings.HTTP)
+	if !clientInitialized || changed {
+		// Local providers usually accept any key, and replayed or faked
+		// requests never reach the provider; hosted ones need one
+		if settings.APIKey == "" && settings.Provider != config.ProviderLocal && !Replaying() && !usingFakeProvider() {
+			clientInitialized = false
+			return nil, settings, fmt.Errorf("no API key configured; run /config or set CODEAID_OPENROUTER_API_KEY")
 		}
 
-		// Initialize client only if we have an API key
-		if apiKey != "" {
-			openAIConfig := openai.DefaultConfig(apiKey)
-			openAIConfig.BaseURL = "https://openrouter.ai/api/v1"
-			apiClient = openai.NewClientWithConfig(openAIConfig)
-			clientInitialized = true
+		httpClient, err := newHTTPClient(settings)
+		if err != nil {
+			clientInitialized = false
+			return nil, settings, err
 		}
+
+		openAIConfig := openai.DefaultConfig(settings.APIKey)
+		openAIConfig.BaseURL = settings.BaseURL
+		openAIConfig.HTTPClient = httpClient
+		apiClient = openai.NewClientWithConfig(openAIConfig)
+		clientInitialized = true
 	}
+	clientSettings = settings
+
+	return apiClient, settings, nil
+}
 
-	return apiClient
+// SwitchProfile activates a named profile for the rest of the session.
+// The cached client is rebuilt on the next request.
+func SwitchProfile(name string) error {
+	return config.SetSessionProfile(name)
+}
+
+// ActiveProfile returns the name of the active profile, or "" if none
+func ActiveProfile() string {
+	settings, err := currentSettings()
+	if err != nil {
+		return ""
+	}
+	return settings.Profile
 }
 
 // GetModel returns the model to use for API requests
 func GetModel() string {
-	// Try to load from config file
-	cfg, err := config.Load()
-	if err == nil && cfg != nil && cfg.Model != "" {
-		return cfg.Model
+	// Use the effective model from all configuration layers and the profile
+	settings, err := currentSettings()
+	if err == nil && settings.Model != "" {
+		return settings.Model
 	}
 	
 	// Fall back to default model
@@ -99,24 +163,68 @@ func MaskAPIKey(key string) string {
 
 // ClearHistory resets the conversation history
 func ClearHistory() tea.Msg {
-	conversationMux.Lock()
-	defer conversationMux.Unlock()
-
-	conversationHistory = nil
+	conversationTree.Clear()
 	return messages.ClearHistoryMsg{}
 }
 
-// AddMessageToHistory adds an assistant message to the conversation history
-// This is called only after a response is successfully displayed and not canceled
-func AddMessageToHistory(content string) {
-	conversationMux.Lock()
-	defer conversationMux.Unlock()
+// AddNote adds display-only text, such as command output, to the
+// conversation. Notes are shown in the chat but never sent to the model.
+func AddNote(output string) {
+	conversationTree.AddNote("", output)
+}
+
+// UndoLast removes the last exchange and its retries from the active
+// branch, reporting whether an edited version of the message is now shown
+func UndoLast() (conversation.Turn, bool, error) {
+	return conversationTree.Undo()
+}
+
+// ConversationPath returns the turns on the active branch, oldest first
+func ConversationPath() []conversation.Turn {
+	return conversationTree.Path()
+}
+
+// RetryLast starts a new branch that regenerates the last answer
+func RetryLast() error {
+	_, err := conversationTree.Retry()
+	return err
+}
+
+// EditTurn starts a new branch replacing the index-th user message
+// (1-based) with prompt
+func EditTurn(index int, prompt string) error {
+	_, err := conversationTree.Edit(index, prompt)
+	return err
+}
 
-	assistantMessage := openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleAssistant,
-		Content: content,
+// PromptAt returns the index-th user message (1-based) on the active
+// branch; index 0 means the last one
+func PromptAt(index int) (int, string, error) {
+	if index == 0 {
+		index = conversationTree.PromptCount()
 	}
-	conversationHistory = append(conversationHistory, assistantMessage)
+	prompt, err := conversationTree.PromptAt(index)
+	return index, prompt, err
+}
+
+// PromptCount returns the number of user messages on the active branch
+func PromptCount() int {
+	return conversationTree.PromptCount()
+}
+
+// Branches lists the user messages on the active branch with alternatives
+func Branches() []conversation.Branch {
+	return conversationTree.Branches()
+}
+
+// SelectBranch activates an alternative of the index-th user message
+func SelectBranch(index, alternative int) error {
+	return conversationTree.SelectBranch(index, alternative)
+}
+
+// SwitchBranch cycles the deepest alternative on the active branch
+func SwitchBranch(delta int) bool {
+	return conversationTree.SwitchBranch(delta)
 }
 
 // ProcessUserInput handles user input and checks for commands
@@ -148,6 +256,8 @@ func ExecuteCommand(input string) tea.Cmd {
 	if commandHandler != nil {
 		cmd := commandHandler.GetCommand(cmdName)
 		if cmd != nil {
+			// Show the command in the conversation; it is never sent
+			conversationTree.AddNote(input, "")
 			return cmd.Execute(args)
 		}
 	}
@@ -156,91 +266,359 @@ func ExecuteCommand(input string) tea.Cmd {
 	return FetchReply(input)
 }
 
-// CancelCurrentRequest cancels any ongoing API request
+// CancelCurrentRequest cancels any ongoing API request and marks its turn
+// as cancelled so it is not sent with later requests. A response that is
+// still on its way is reported as stale by EndRequest.
 func CancelCurrentRequest() {
-	if currentCancelFunc != nil {
-		currentCancelFunc() // Cancel the current context
+	requestMux.Lock()
+	defer requestMux.Unlock()
+
+	conversationTree.CancelPending()
+	if activeRequest != nil {
+		activeRequest.cancel()
+		activeRequest = nil
 	}
 }
 
-// FetchReply creates a tea.Cmd that fetches a reply with guaranteed completion
+// EndRequest marks the request with the given ID as finished. It reports
+// false if that request is no longer the active one, in which case its
+// response is stale and should be ignored.
+func EndRequest(id int) bool {
+	requestMux.Lock()
+	defer requestMux.Unlock()
+
+	if activeRequest == nil || activeRequest.id != id {
+		return false
+	}
+	activeRequest = nil
+	return true
+}
+
+// startRequest registers a new active request for a turn (0 if the
+// request has none), cancelling any request that is still running. The
+// returned context is only cancelled; callers add the request timeout
+// when the request actually runs, so time spent waiting for the tea.Cmd
+// to be scheduled does not count against it.
+func startRequest(turnID int) (*request, context.Context) {
+	requestMux.Lock()
+	defer requestMux.Unlock()
+
+	if activeRequest != nil {
+		activeRequest.cancel()
+		if activeRequest.turnID != 0 {
+			conversationTree.Cancel(activeRequest.turnID)
+		}
+	}
+
+	id := nextRequestID
+	nextRequestID++
+	ctx, cancel := context.WithCancel(withRequestID(context.Background(), id))
+	activeRequest = &request{id: id, turnID: turnID, cancel: cancel, state: RequestWaiting}
+	return activeRequest, ctx
+}
+
+// FetchReply creates a tea.Cmd that sends a new prompt and fetches the reply.
+// The turn is added to the conversation immediately so it shows as pending.
+// Staged images and @image references in the prompt are attached to it.
 func FetchReply(prompt string) tea.Cmd {
-	return func() tea.Msg {
-		// Create result channel with buffer to avoid blocking
-		resultChan := make(chan tea.Msg, 1)
+	turn := conversationTree.Begin(prompt, nil)
+	handler := turnHandler(turn.ID)
+	handler.prepare = func() error {
+		attachments, err := promptAttachments(prompt)
+		if err != nil {
+			return err
+		}
+		if len(attachments) > 0 {
+			ClearAttachments()
+			conversationTree.Attach(turn.ID, attachments)
+		}
+		return nil
+	}
+	return sendRequest(handler)
+}
+
+// FetchPending creates a tea.Cmd that fetches the reply for the last turn,
+// e.g. after /retry or /edit started a new branch
+func FetchPending() tea.Cmd {
+	leaf, ok := conversationTree.Leaf()
+	if !ok || leaf.Kind != conversation.KindChat || leaf.State != conversation.StatePending {
+		return func() tea.Msg {
+			return messages.CommandResponseMsg("Error: no message waiting for a reply")
+		}
+	}
+	return fetchTurn(leaf.ID)
+}
 
-		// Set up a failsafe timeout
-		timer := time.NewTimer(15 * time.Second)
-		defer timer.Stop()
+// requestHandler connects a request to the conversation: it builds the
+// messages to send and stores the outcome. prepare, if set, runs first
+// inside the tea.Cmd, for work too slow for Update such as reading images;
+// an error from it fails the request. stream, if set, receives the reply
+// as it arrives.
+type requestHandler struct {
+	turnID   int
+	prepare  func() error
+	messages func() []openai.ChatCompletionMessage
+	stream   func(delta string)
+	complete func(reply string, meta conversation.Metadata) bool
+	fail     func(message string)
+}
 
-		// Create a cancellable context and store its cancel function globally
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// fetchTurn creates a tea.Cmd that fetches the reply for a pending turn
+func fetchTurn(turnID int) tea.Cmd {
+	return sendRequest(turnHandler(turnID))
+}
 
-		// Store the cancel function so it can be called when user cancels
-		currentCancelFunc = cancel
+// turnHandler returns the handler that fetches the reply for a pending
+// turn and stores it on the turn
+func turnHandler(turnID int) requestHandler {
+	return requestHandler{
+		turnID: turnID,
+		messages: func() []openai.ChatCompletionMessage {
+			return conversationTree.Messages(turnID)
+		},
+		stream: func(delta string) {
+			conversationTree.Stream(turnID, delta)
+		},
+		complete: func(reply string, meta conversation.Metadata) bool {
+			return conversationTree.Complete(turnID, reply, meta)
+		},
+		fail: func(message string) {
+			conversationTree.Fail(turnID, message)
+		},
+	}
+}
 
-		// Launch API call in goroutine
-		go func() {
-			// Make sure to clean up
-			defer cancel()
-			defer func() { currentCancelFunc = nil }()
+// sendRequest creates a tea.Cmd that sends a request with guaranteed
+// completion. The request is registered as soon as the command is
+// created so it can be cancelled, its timeout starts when the command
+// runs, and the outcome is stored in the conversation before the
+// resulting message is delivered, so the view and history always agree.
+func sendRequest(handler requestHandler) tea.Cmd {
+	req, parent := startRequest(handler.turnID)
 
-			client := initClient()
+	return func() tea.Msg {
+		defer req.cancel()
 
-			// Add user message to history
-			userMessage := openai.ChatCompletionMessage{
-				Role:    openai.ChatMessageRoleUser,
-				Content: prompt,
+		if handler.prepare != nil {
+			err := handler.prepare()
+			if parent.Err() != nil {
+				conversationTree.Cancel(handler.turnID)
+				return messages.CancelMsg{RequestID: req.id}
+			}
+			if err != nil {
+				handler.fail(err.Error())
+				return messages.ResponseMsg{RequestID: req.id, Content: "Error: " + err.Error()}
 			}
+		}
 
-			// Update conversation history
-			conversationMux.Lock()
-			conversationHistory = append(conversationHistory, userMessage)
-			messagesCopy := make([]openai.ChatCompletionMessage, len(conversationHistory))
-			copy(messagesCopy, conversationHistory)
-			conversationMux.Unlock()
-
-			// Make API request with full conversation history
-			resp, err := client.CreateChatCompletion(
-				ctx,
-				openai.ChatCompletionRequest{
-					Model:       GetModel(),
-					MaxTokens:   1024,
-					Temperature: 0.7,
-					Messages:    messagesCopy,
-				},
-			)
+		timeout := config.DefaultRequestTimeout
+		if settings, err := currentSettings(); err == nil {
+			timeout = settings.HTTP.RequestTimeout()
+		}
+		ctx, cancel := withIdleTimeout(parent, timeout)
+		defer cancel()
+
+		// Create result channel with buffer to avoid blocking
+		resultChan := make(chan tea.Msg, 1)
+
+		// fail records an error and reports it
+		fail := func(message string) tea.Msg {
+			handler.fail(message)
+			return messages.ResponseMsg{RequestID: req.id, Content: "Error: " + message}
+		}
+
+		// Launch API call in goroutine
+		go func() {
+			reply, meta, err := requestReply(ctx, handler.messages(), func(delta string) {
+				if handler.stream != nil {
+					handler.stream(delta)
+				}
+				notifyStream(handler.turnID, delta)
+			})
 
 			// Check if context was canceled before sending response
 			select {
 			case <-ctx.Done():
-				// Context was canceled, don't send a response
+				// Context was canceled or timed out; handled below
 				return
 			default:
 				// Context not canceled, proceed with normal response
 				if err != nil {
-					resultChan <- messages.ResponseMsg("Error: " + err.Error())
-				} else if len(resp.Choices) == 0 {
-					resultChan <- messages.ResponseMsg("Error: No response received from API")
+					resultChan <- fail(err.Error())
+					return
+				}
+				if handler.complete(reply, meta) {
+					resultChan <- messages.ResponseMsg{RequestID: req.id, Content: reply}
 				} else {
-					// Send response - it will be added to history after displaying
-					resultChan <- messages.ResponseMsg(resp.Choices[0].Message.Content)
+					// The turn was cancelled or removed while the reply was on its way
+					resultChan <- messages.CancelMsg{RequestID: req.id}
 				}
 			}
 		}()
 
-		// Wait for either a result or timeout
+		// Wait for either a result or timeout; a request that ignores its
+		// context is abandoned when the context ends
 		select {
 		case result := <-resultChan:
 			// Normal result path
 			return result
-		case <-timer.C:
-			// Timeout path
-			cancel() // Make sure to cancel the context on timeout
-			return messages.ResponseMsg("Error: Request timed out after 15 seconds. Please try again.")
 		case <-ctx.Done():
+			if timedOut(ctx) {
+				return fail(fmt.Sprintf("Request timed out after %s. Please try again.", timeout))
+			}
 			// Context was canceled, return CancelMsg
-			return messages.CancelMsg{}
+			conversationTree.Cancel(handler.turnID)
+			return messages.CancelMsg{RequestID: req.id}
+		}
+	}
+}
+
+// requestReply sends messages to the model with the current settings and
+// streams the reply, passing each piece to onDelta as it arrives. It
+// returns the whole reply with its metadata.
+func requestReply(ctx context.Context, msgs []openai.ChatCompletionMessage, onDelta func(string)) (string, conversation.Metadata, error) {
+	client, settings, err := initClient()
+	if err != nil {
+		return "", conversation.Metadata{}, err
+	}
+	if hasImages(msgs) {
+		if err := requireVision(settings.Model); err != nil {
+			return "", conversation.Metadata{}, err
+		}
+	}
+
+	// Make API request with full conversation history
+	start := time.Now()
+	stream, err := withRetries(ctx, func() (*openai.ChatCompletionStream, error) {
+		return client.CreateChatCompletionStream(
+			ctx,
+			openai.ChatCompletionRequest{
+				Model:         settings.Model,
+				MaxTokens:     settings.MaxTokens,
+				Temperature:   requestTemperature(settings.Temperature),
+				Messages:      msgs,
+				StreamOptions: &openai.StreamOptions{IncludeUsage: true},
+			},
+		)
+	})
+	if err != nil {
+		return "", conversation.Metadata{}, err
+	}
+	defer stream.Close()
+
+	resp, err := receiveStream(ctx, stream, onDelta)
+	latency := time.Since(start)
+	if err != nil {
+		return "", conversation.Metadata{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return "", conversation.Metadata{}, fmt.Errorf("No response received from API")
+	}
+
+	return resp.Choices[0].Message.Content, responseMetadata(settings.Model, resp, latency), nil
+}
+
+// CompleteTurn fetches the reply for a pending chat turn of tree and
+// stores the outcome on the turn. It is the request logic behind
+// FetchReply for callers that keep their own conversations, such as the
+// API server; cancelling ctx cancels the turn. onDelta, if not nil,
+// receives the reply as it arrives.
+func CompleteTurn(ctx context.Context, tree *conversation.Tree, turnID int, onDelta func(string)) (conversation.Turn, error) {
+	timeout := config.DefaultRequestTimeout
+	if settings, err := currentSettings(); err == nil {
+		timeout = settings.HTTP.RequestTimeout()
+	}
+	ctx, cancel := withIdleTimeout(ctx, timeout)
+	defer cancel()
+
+	reply, meta, err := requestReply(ctx, tree.Messages(turnID), func(delta string) {
+		tree.Stream(turnID, delta)
+		if onDelta != nil {
+			onDelta(delta)
 		}
+	})
+	switch {
+	case timedOut(ctx):
+		err = fmt.Errorf("Request timed out after %s. Please try again.", timeout)
+		tree.Fail(turnID, err.Error())
+	case ctx.Err() != nil:
+		tree.Cancel(turnID)
+		err = ctx.Err()
+	case err != nil:
+		tree.Fail(turnID, err.Error())
+	case !tree.Complete(turnID, reply, meta):
+		err = fmt.Errorf("turn %d was cancelled", turnID)
+	}
+
+	turn, _ := tree.Turn(turnID)
+	return turn, err
+}
+
+// responseMetadata extracts the reply metadata from an API response and
+// adds its cost to the session
+func responseMetadata(model string, resp openai.ChatCompletionResponse, latency time.Duration) conversation.Metadata {
+	// Prefer the model reported by the API, which may differ from the
+	// requested one (for example when a provider routes the request)
+	if resp.Model != "" {
+		model = resp.Model
+	}
+	meta := conversation.Metadata{
+		Model:            model,
+		Latency:          latency,
+		PromptTokens:     resp.Usage.PromptTokens,
+		CompletionTokens: resp.Usage.CompletionTokens,
 	}
-}
\ No newline at end of file
+	if len(resp.Choices) > 0 {
+		meta.FinishReason = string(resp.Choices[0].FinishReason)
+	}
+	recordUsage(meta)
+	return meta
+}
+
+// continuePrompt asks the model to resume a reply that was cut off
+const continuePrompt = "Your previous reply was cut off. Continue exactly where it stopped, without repeating anything and without any introduction."
+
+// ContinueLast creates a tea.Cmd that asks the model to resume the last
+// reply where it stopped and appends the result to the same reply. Only a
+// reply cut off at the token limit can be continued.
+func ContinueLast() tea.Cmd {
+	turn, ok := conversationTree.LastPrompted()
+	if !ok || turn.State != conversation.StateCompleted {
+		return func() tea.Msg {
+			return messages.CommandResponseMsg("Error: there is no completed reply to continue")
+		}
+	}
+	if !turn.Meta.Truncated() {
+		return func() tea.Msg {
+			return messages.CommandResponseMsg("Error: the last reply was not cut off")
+		}
+	}
+
+	return sendRequest(requestHandler{
+		turnID: turn.ID,
+		messages: func() []openai.ChatCompletionMessage {
+			msgs := conversationTree.Messages(turn.ID)
+			return append(msgs,
+				openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: turn.Reply},
+				openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: continuePrompt},
+			)
+		},
+		complete: func(reply string, meta conversation.Metadata) bool {
+			return conversationTree.Extend(turn.ID, reply, meta)
+		},
+		fail: func(message string) {
+			// The reply itself is complete; report the failed continuation
+			conversationTree.AddNote("", "Error: could not continue: "+message)
+		},
+	})
+}
+
+// GetHistory returns a copy of the conversation history on the active branch
+func GetHistory() []openai.ChatCompletionMessage {
+	return conversationTree.History()
+}
+
+// InjectMessages appends messages to the conversation history without sending them
+func InjectMessages(msgs []openai.ChatCompletionMessage) {
+	conversationTree.Inject(msgs)
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"codeaid/config"
+	"codeaid/conversation"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// maxImageSize is the largest image accepted as an attachment
+const maxImageSize = 20 << 20
+
+// imageTypes maps the supported image extensions to their MIME types
+var imageTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// Images attached with /image wait here until the next message is sent
+var (
+	stagedAttachments []conversation.Attachment
+	attachmentMux     sync.Mutex
+)
+
+// IsImagePath reports whether a path has a supported image extension
+func IsImagePath(path string) bool {
+	_, ok := imageTypes[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// LoadImage reads an image file and encodes it as an attachment
+func LoadImage(path string) (conversation.Attachment, error) {
+	if !IsImagePath(path) {
+		return conversation.Attachment{}, fmt.Errorf("%s: not a supported image (png, jpg, gif or webp)", path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return conversation.Attachment{}, err
+	}
+	if info.Size() > maxImageSize {
+		return conversation.Attachment{}, fmt.Errorf("%s: image is larger than %d MB", path, maxImageSize>>20)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return conversation.Attachment{}, err
+	}
+
+	// Trust the content over the extension
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return conversation.Attachment{}, fmt.Errorf("%s: file content is not an image", path)
+	}
+
+	return conversation.Attachment{
+		Path:     path,
+		MIMEType: mimeType,
+		Data:     base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+// AttachImage stages an image to be sent with the next message
+func AttachImage(path string) (conversation.Attachment, error) {
+	attachment, err := LoadImage(path)
+	if err != nil {
+		return attachment, err
+	}
+
+	attachmentMux.Lock()
+	defer attachmentMux.Unlock()
+
+	stagedAttachments = append(stagedAttachments, attachment)
+	return attachment, nil
+}
+
+// StagedAttachments returns the paths of the images waiting to be sent
+func StagedAttachments() []string {
+	attachmentMux.Lock()
+	defer attachmentMux.Unlock()
+
+	paths := make([]string, 0, len(stagedAttachments))
+	for _, attachment := range stagedAttachments {
+		paths = append(paths, attachment.Path)
+	}
+	return paths
+}
+
+// ClearAttachments discards the staged images
+func ClearAttachments() {
+	attachmentMux.Lock()
+	defer attachmentMux.Unlock()
+
+	stagedAttachments = nil
+}
+
+// promptAttachments collects the staged images and any @path references
+// to image files in the prompt. Staged images are only cleared once the
+// prompt is accepted, so they are kept if validation fails. Images are
+// read from disk, so this runs inside a tea.Cmd rather than in Update.
+func promptAttachments(prompt string) ([]conversation.Attachment, error) {
+	attachmentMux.Lock()
+	attachments := append([]conversation.Attachment{}, stagedAttachments...)
+	attachmentMux.Unlock()
+
+	for _, word := range strings.Fields(prompt) {
+		if !strings.HasPrefix(word, "@") {
+			continue
+		}
+		path := strings.TrimRight(word[1:], ".,;:!?)\"'")
+		if !IsImagePath(path) {
+			continue
+		}
+		attachment, err := LoadImage(path)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+
+	settings, err := currentSettings()
+	if err != nil {
+		return nil, err
+	}
+	if err := requireVision(settings.Model); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// requireVision returns an error unless the model accepts images
+func requireVision(model string) error {
+	resolved, err := config.Resolve()
+	if err != nil {
+		return err
+	}
+	if !resolved.SupportsVision(model) {
+		return fmt.Errorf("model %s does not accept images; switch models or add it to vision_models in config.json", model)
+	}
+	return nil
+}
+
+// hasImages reports whether any of the messages carries an image. Turns
+// reused by /retry, /edit and /compare keep their images, and earlier
+// turns resend theirs, so every request is checked, not only new prompts.
+func hasImages(msgs []openai.ChatCompletionMessage) bool {
+	for _, msg := range msgs {
+		for _, part := range msg.MultiContent {
+			if part.Type == openai.ChatMessagePartTypeImageURL {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"bytes"
+	"codeaid/config"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cassette modes: record saves every API exchange to a cassette file,
+// replay answers requests from cassette files without any network access
+const (
+	CassetteRecord = "record"
+	CassetteReplay = "replay"
+)
+
+var (
+	cassetteMode string
+	cassetteDir  string
+	cassetteMux  sync.Mutex
+)
+
+// cassette is one recorded exchange. Model and Messages are kept for
+// readability; replay matches on Key, a hash of both. A streamed reply is
+// kept as the server-sent events in Stream, any other as the JSON Body.
+type cassette struct {
+	Key        string          `json:"key"`
+	RecordedAt time.Time       `json:"recorded_at"`
+	Model      string          `json:"model"`
+	Messages   json.RawMessage `json:"messages"`
+	Status     int             `json:"status"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	Stream     string          `json:"stream,omitempty"`
+}
+
+// SetCassette selects record or replay mode with the cassette directory.
+// It must be called before the first request.
+func SetCassette(mode, dir string) error {
+	switch mode {
+	case CassetteRecord:
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	case CassetteReplay:
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("cassette directory %s not found", dir)
+		}
+	default:
+		return fmt.Errorf("unknown cassette mode %q", mode)
+	}
+
+	cassetteMux.Lock()
+	defer cassetteMux.Unlock()
+
+	cassetteMode = mode
+	cassetteDir = dir
+	return nil
+}
+
+// Replaying reports whether requests are answered from cassettes
+func Replaying() bool {
+	cassetteMux.Lock()
+	defer cassetteMux.Unlock()
+
+	return cassetteMode == CassetteReplay
+}
+
+// cassetteTransport is an http.RoundTripper that records exchanges to
+// cassette files or replays them
+type cassetteTransport struct {
+	base http.RoundTripper
+	mode string
+	dir  string
+}
+
+// newCassetteTransport wraps base when a cassette mode is active
+func newCassetteTransport(base http.RoundTripper) http.RoundTripper {
+	cassetteMux.Lock()
+	defer cassetteMux.Unlock()
+
+	if cassetteMode == "" {
+		return base
+	}
+	return &cassetteTransport{base: base, mode: cassetteMode, dir: cassetteDir}
+}
+
+// RoundTrip records or replays a chat completion request
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	key, model, msgs, err := cassetteKey(body)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(t.dir, key+".json")
+
+	if t.mode == CassetteReplay {
+		return replayCassette(req, path, model, requestsStream(body))
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := cassette{
+		Key:        key,
+		RecordedAt: time.Now().UTC(),
+		Model:      model,
+		Messages:   msgs,
+		Status:     resp.StatusCode,
+	}
+
+	// A streamed reply passes through as it arrives and is recorded once
+	// it has been read to the end
+	if isEventStream(resp.Header) {
+		resp.Body = newStreamBody(resp.Body, func(events []byte) {
+			if resp.StatusCode == http.StatusOK && streamComplete(events) {
+				recorded.Stream = string(events)
+				writeCassette(path, recorded)
+			}
+		})
+		return resp, nil
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	// Only successful JSON responses are worth replaying
+	if resp.StatusCode == http.StatusOK && json.Valid(respBody) {
+		recorded.Body = respBody
+		writeCassette(path, recorded)
+	}
+	return resp, nil
+}
+
+// writeCassette saves a recorded exchange
+func writeCassette(path string, recorded cassette) {
+	if data, err := json.MarshalIndent(recorded, "", "  "); err == nil {
+		_ = config.WriteFileAtomic(path, append(data, '\n'))
+	}
+}
+
+// replayCassette answers a request from its cassette file. A streaming
+// request for a cassette recorded without streaming gets the recorded
+// reply as server-sent events.
+func replayCassette(req *http.Request, path, model string, stream bool) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no cassette recorded for this conversation with model %s (%s)", model, filepath.Base(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var recorded cassette
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %v", path, err)
+	}
+
+	body, contentType := []byte(recorded.Body), "application/json"
+	switch {
+	case recorded.Stream != "":
+		body, contentType = []byte(recorded.Stream), "text/event-stream"
+	case stream:
+		if body, err = completionStream(body); err != nil {
+			return nil, fmt.Errorf("invalid cassette %s: %v", path, err)
+		}
+		contentType = "text/event-stream"
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{contentType}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// cassetteKey hashes the model and messages of a request body. Other
+// parameters such as temperature do not affect matching.
+func cassetteKey(body []byte) (key, model string, msgs json.RawMessage, err error) {
+	var request struct {
+		Model    string          `json:"model"`
+		Messages json.RawMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return "", "", nil, fmt.Errorf("cassette: request is not JSON: %v", err)
+	}
+
+	// Re-encode the messages so formatting differences do not matter
+	var decoded interface{}
+	if err := json.Unmarshal(request.Messages, &decoded); err != nil {
+		return "", "", nil, fmt.Errorf("cassette: invalid messages: %v", err)
+	}
+	canonical, err := json.Marshal(decoded)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	sum := sha256.Sum256(append([]byte(request.Model+"\n"), canonical...))
+	return hex.EncodeToString(sum[:])[:32], request.Model, canonical, nil
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"codeaid/config"
+	"codeaid/conversation"
+	"codeaid/messages"
+)
+
+// replayCassettes answers requests from testdata/cassettes with an empty
+// configuration that selects the recorded model
+func replayCassettes(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"version": 1, "model": "openai/gpt-4o-mini"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.Isolate(dir)
+	if err := SetCassette(CassetteReplay, filepath.Join("testdata", "cassettes")); err != nil {
+		t.Fatal(err)
+	}
+	conversationTree.Clear()
+
+	t.Cleanup(func() {
+		config.Isolate("")
+		cassetteMux.Lock()
+		cassetteMode, cassetteDir = "", ""
+		cassetteMux.Unlock()
+		clientInitMux.Lock()
+		clientInitialized = false
+		clientInitMux.Unlock()
+		conversationTree.Clear()
+	})
+}
+
+// The first cassette holds a reply recorded without streaming, which is
+// replayed as server-sent events; the second holds a recorded stream
+func TestFetchReplyReplaysCassettes(t *testing.T) {
+	replayCassettes(t)
+	var pieces []string
+	SetStreamHandler(func(turnID int, delta string) { pieces = append(pieces, delta) })
+	t.Cleanup(func() { SetStreamHandler(nil) })
+
+	exchanges := []struct{ prompt, reply string }{
+		{"What is the capital of France?", "The capital of France is Paris."},
+		{"And of Italy?", "The capital of Italy is Rome."},
+	}
+	for _, exchange := range exchanges {
+		pieces = nil
+		msg := FetchReply(exchange.prompt)()
+		if resp, ok := msg.(messages.ResponseMsg); !ok || resp.Content != exchange.reply {
+			t.Fatalf("%q: got %#v, want reply %q", exchange.prompt, msg, exchange.reply)
+		}
+		EndRequest(msg.(messages.ResponseMsg).RequestID)
+		if len(pieces) < 2 || strings.Join(pieces, "") != exchange.reply {
+			t.Errorf("%q: streamed %q, want the reply in pieces", exchange.prompt, pieces)
+		}
+	}
+
+	turn, ok := conversationTree.LastPrompted()
+	if !ok || turn.State != conversation.StateCompleted {
+		t.Fatalf("last turn is %+v, want a completed turn", turn)
+	}
+	meta := turn.Meta
+	if meta.Model != "openai/gpt-4o-mini" || meta.PromptTokens != 34 || meta.CompletionTokens != 8 || meta.FinishReason != "stop" {
+		t.Errorf("metadata = %+v, want the recorded model, usage and finish reason", meta)
+	}
+}
+
+func TestFetchReplyWithoutCassetteFails(t *testing.T) {
+	replayCassettes(t)
+
+	msg := FetchReply("Something never recorded")()
+	resp, ok := msg.(messages.ResponseMsg)
+	if !ok || !strings.Contains(resp.Content, "no cassette recorded") {
+		t.Fatalf("got %#v, want an error naming the missing cassette", msg)
+	}
+	EndRequest(resp.RequestID)
+
+	if turn, _ := conversationTree.LastPrompted(); turn.State != conversation.StateFailed {
+		t.Errorf("turn state = %s, want failed", turn.State)
+	}
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"codeaid/conversation"
+	"encoding/base64"
+	"errors"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// CopyToClipboard returns a command asking the terminal to put text on
+// the system clipboard with an OSC 52 escape sequence, which also works
+// over SSH. Terminals without OSC 52 support ignore it.
+func CopyToClipboard(text string) (tea.Cmd, error) {
+	cmd := writeTerminal("\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a")
+	if cmd == nil {
+		return nil, errors.New("copying needs a terminal")
+	}
+	return cmd, nil
+}
+
+// LastReply returns the text of the most recent completed reply on the
+// current branch
+func LastReply() (string, bool) {
+	path := conversationTree.Path()
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i].Kind == conversation.KindChat && path[i].Reply != "" {
+			return path[i].Reply, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"codeaid/conversation"
+	"codeaid/messages"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/bubbletea"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// compareTimeout limits how long a comparison waits for the slowest model
+// unless http.timeout is set
+const compareTimeout = 60 * time.Second
+
+// CompareModels creates a tea.Cmd that sends the conversation up to the
+// last user message to each model concurrently and collects the answers.
+// The conversation itself is not changed until an answer is chosen.
+func CompareModels(models []string) tea.Cmd {
+	turn, ok := conversationTree.LastPrompted()
+	if !ok {
+		return func() tea.Msg {
+			return messages.CommandResponseMsg("Error: send a message first, then compare answers to it")
+		}
+	}
+
+	req, parent := startRequest(0)
+	return func() tea.Msg {
+		defer req.cancel()
+
+		timeout := compareTimeout
+		if settings, err := currentSettings(); err == nil && settings.HTTP.Timeout > 0 {
+			timeout = settings.HTTP.RequestTimeout()
+		}
+		ctx, cancel := context.WithTimeout(parent, timeout)
+		defer cancel()
+
+		results := make([]messages.CompareResult, len(models))
+		client, settings, err := initClient()
+		if err != nil {
+			for i, model := range models {
+				results[i] = messages.CompareResult{Model: model, Error: err.Error()}
+			}
+			return messages.CompareMsg{RequestID: req.id, TurnID: turn.ID, Prompt: turn.Prompt, Results: results}
+		}
+		history := conversationTree.Messages(turn.ID)
+
+		var wg sync.WaitGroup
+		for i, model := range models {
+			wg.Add(1)
+			go func(i int, model string) {
+				defer wg.Done()
+				if hasImages(history) {
+					if err := requireVision(model); err != nil {
+						results[i] = messages.CompareResult{Model: model, Error: err.Error()}
+						return
+					}
+				}
+				results[i] = compareOne(ctx, timeout, client, openai.ChatCompletionRequest{
+					Model:       model,
+					MaxTokens:   settings.MaxTokens,
+					Temperature: requestTemperature(settings.Temperature),
+					Messages:    history,
+				})
+			}(i, model)
+		}
+		wg.Wait()
+
+		if ctx.Err() == context.Canceled {
+			return messages.CancelMsg{RequestID: req.id}
+		}
+		return messages.CompareMsg{RequestID: req.id, TurnID: turn.ID, Prompt: turn.Prompt, Results: results}
+	}
+}
+
+// compareOne sends a single request of a comparison and measures it
+func compareOne(ctx context.Context, timeout time.Duration, client *openai.Client, request openai.ChatCompletionRequest) messages.CompareResult {
+	result := messages.CompareResult{Model: request.Model}
+
+	start := time.Now()
+	resp, err := client.CreateChatCompletion(ctx, request)
+	result.Latency = time.Since(start)
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result.Error = fmt.Sprintf("timed out after %s", timeout)
+	case err != nil:
+		result.Error = err.Error()
+	case len(resp.Choices) == 0:
+		result.Error = "No response received from API"
+	default:
+		meta := responseMetadata(request.Model, resp, result.Latency)
+		result.Model = meta.Model
+		result.Reply = resp.Choices[0].Message.Content
+		result.PromptTokens = meta.PromptTokens
+		result.CompletionTokens = meta.CompletionTokens
+		result.FinishReason = meta.FinishReason
+	}
+	return result
+}
+
+// ContinueWith adds a model's answer from a comparison as a new branch of
+// the compared message, so the conversation continues from it
+func ContinueWith(turnID int, result messages.CompareResult) error {
+	_, err := conversationTree.Alternative(turnID, result.Reply, conversation.Metadata{
+		Model:            result.Model,
+		Latency:          result.Latency,
+		PromptTokens:     result.PromptTokens,
+		CompletionTokens: result.CompletionTokens,
+		FinishReason:     result.FinishReason,
+	})
+	return err
+}
@@ -0,0 +1,344 @@
+package utils
+
+import (
+	"bytes"
+	"codeaid/config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Debug log rotation: the log is rotated when it grows past
+// maxDebugLogSize, keeping maxDebugLogFiles old files
+const (
+	debugLogName     = "debug.jsonl"
+	maxDebugLogSize  = 5 << 20
+	maxDebugLogFiles = 3
+)
+
+// redacted replaces secrets in debug output
+const redacted = "[REDACTED]"
+
+// builtinSecretPatterns match API keys of the supported providers
+var builtinSecretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`sk-or-v1-[A-Za-z0-9]+`),
+	regexp.MustCompile(`sk-(proj-)?[A-Za-z0-9_-]{20,}`),
+}
+
+// sensitiveHeaders are never logged as they are
+var sensitiveHeaders = map[string]bool{
+	"Authorization": true,
+	"Cookie":        true,
+	"Set-Cookie":    true,
+	"X-Api-Key":     true,
+}
+
+// requestIDKey carries the request ID in a request context so logged
+// exchanges can be matched with the conversation
+type requestIDKey struct{}
+
+// Exchange is one HTTP request and its response, with secrets redacted
+type Exchange struct {
+	RequestID int           `json:"request_id,omitempty"`
+	Time      time.Time     `json:"time"`
+	Method    string        `json:"method"`
+	URL       string        `json:"url"`
+	Request   DebugMessage  `json:"request"`
+	Response  *DebugMessage `json:"response,omitempty"`
+	Status    int           `json:"status,omitempty"`
+	Duration  float64       `json:"duration_ms"`
+	ToolCalls int           `json:"tool_calls,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// DebugMessage holds the headers and body of a request or response
+type DebugMessage struct {
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+	Text    string            `json:"text,omitempty"` // Body that is not JSON
+}
+
+var (
+	lastExchange *Exchange
+	debugLogMux  sync.Mutex
+)
+
+// GetDebugLogPath returns the path of the debug log file
+func GetDebugLogPath() (string, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "logs", debugLogName), nil
+}
+
+// DebugEnabled reports whether debug logging is on
+func DebugEnabled() bool {
+	resolved, err := config.Resolve()
+	return err == nil && resolved.Data.Debug
+}
+
+// LastExchange returns the most recent API exchange, if any
+func LastExchange() (Exchange, bool) {
+	debugLogMux.Lock()
+	defer debugLogMux.Unlock()
+
+	if lastExchange == nil {
+		return Exchange{}, false
+	}
+	return *lastExchange, true
+}
+
+// debugTransport is an http.RoundTripper that records every exchange with
+// secrets redacted. The last exchange is kept for /debug last; in debug
+// mode each exchange is also appended to the debug log.
+type debugTransport struct {
+	base    http.RoundTripper
+	enabled bool                // Whether exchanges go to the debug log
+	redact  func(string) string // Removes secrets from logged text
+	hidden  map[string]bool     // Configured headers, hidden like credentials
+}
+
+// newDebugTransport builds the debug transport, resolving the debug
+// settings once rather than on every round trip. Changes to them apply
+// when the client is next rebuilt.
+func newDebugTransport(base http.RoundTripper, settings config.Settings) *debugTransport {
+	t := &debugTransport{base: base, hidden: map[string]bool{}}
+
+	// Headers from http.headers are added above this transport, so they
+	// are logged and may carry credentials
+	headerValues := []string{}
+	for name, value := range settings.HTTP.Headers {
+		t.hidden[http.CanonicalHeaderKey(name)] = true
+		headerValues = append(headerValues, value)
+	}
+
+	resolved, err := config.Resolve()
+	if err != nil {
+		resolved = &config.Resolved{}
+	}
+	t.enabled = settings.Debug
+	t.redact = newRedactor(resolved, append(headerValues, settings.APIKey))
+	return t
+}
+
+// RoundTrip sends the request through the base transport and records it
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	redact := t.redact
+
+	exchange := &Exchange{
+		Time:   time.Now(),
+		Method: req.Method,
+		URL:    redact(req.URL.String()),
+	}
+	if id, ok := req.Context().Value(requestIDKey{}).(int); ok {
+		exchange.RequestID = id
+	}
+
+	// Read the body and put it back so the request can still be sent
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	exchange.Request = t.debugMessage(req.Header, body)
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	exchange.Duration = float64(time.Since(start).Microseconds()) / 1000
+
+	if err != nil {
+		exchange.Error = redact(err.Error())
+		recordExchange(exchange, t.enabled)
+		return nil, err
+	}
+
+	exchange.Status = resp.StatusCode
+
+	// A streamed reply passes through as it arrives and is recorded once
+	// the caller has read it
+	if isEventStream(resp.Header) {
+		header := resp.Header
+		resp.Body = newStreamBody(resp.Body, func(body []byte) {
+			exchange.Duration = float64(time.Since(start).Microseconds()) / 1000
+			response := t.debugMessage(header, body)
+			exchange.Response = &response
+			exchange.ToolCalls = countStreamToolCalls(body)
+			recordExchange(exchange, t.enabled)
+		})
+		return resp, nil
+	}
+
+	respBody, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	if readErr != nil {
+		exchange.Error = redact(readErr.Error())
+	}
+
+	response := t.debugMessage(resp.Header, respBody)
+	exchange.Response = &response
+	exchange.ToolCalls = countToolCalls(respBody)
+	recordExchange(exchange, t.enabled)
+
+	return resp, readErr
+}
+
+// debugMessage captures headers and body with secrets redacted
+func (t *debugTransport) debugMessage(header http.Header, body []byte) DebugMessage {
+	message := DebugMessage{Headers: map[string]string{}}
+	for name, values := range header {
+		canonical := http.CanonicalHeaderKey(name)
+		if sensitiveHeaders[canonical] || t.hidden[canonical] {
+			message.Headers[name] = redacted
+			continue
+		}
+		message.Headers[name] = t.redact(strings.Join(values, ", "))
+	}
+
+	if len(body) == 0 {
+		return message
+	}
+	clean := t.redact(string(body))
+	if json.Valid([]byte(clean)) {
+		message.Body = json.RawMessage(clean)
+	} else {
+		message.Text = clean
+	}
+	return message
+}
+
+// countToolCalls counts the tool calls in a chat completion response
+func countToolCalls(body []byte) int {
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				ToolCalls []json.RawMessage `json:"tool_calls"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return 0
+	}
+
+	count := 0
+	for _, choice := range resp.Choices {
+		count += len(choice.Message.ToolCalls)
+	}
+	return count
+}
+
+// newRedactor returns a function that removes configured API keys, the
+// extra secrets given and anything matching a secret pattern from text
+func newRedactor(resolved *config.Resolved, extra []string) func(string) string {
+	secrets := []string{}
+	patterns := append([]*regexp.Regexp{}, builtinSecretPatterns...)
+
+	for _, secret := range extra {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	if resolved.Data.OpenRouterAPIKey != "" {
+		secrets = append(secrets, resolved.Data.OpenRouterAPIKey)
+	}
+	for _, profile := range resolved.Data.Profiles {
+		if profile.APIKey != "" {
+			secrets = append(secrets, profile.APIKey)
+		}
+	}
+	for _, pattern := range resolved.Data.SecretPatterns {
+		// Invalid patterns are reported by config validation
+		if re, err := regexp.Compile(pattern); err == nil {
+			patterns = append(patterns, re)
+		}
+	}
+
+	// Replace longer secrets first in case one contains another
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+
+	return func(text string) string {
+		for _, secret := range secrets {
+			text = strings.ReplaceAll(text, secret, redacted)
+		}
+		for _, pattern := range patterns {
+			text = pattern.ReplaceAllString(text, redacted)
+		}
+		return text
+	}
+}
+
+// recordExchange keeps the exchange for /debug last and, if logging is
+// enabled, appends it to the debug log
+func recordExchange(exchange *Exchange, logging bool) {
+	debugLogMux.Lock()
+	defer debugLogMux.Unlock()
+
+	lastExchange = exchange
+	if !logging {
+		return
+	}
+
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return
+	}
+	// Logging must never break a request, so write errors are ignored
+	_ = appendDebugLog(append(line, '\n'))
+}
+
+// appendDebugLog appends a line to the debug log, rotating it first if it
+// has grown too large
+func appendDebugLog(line []byte) error {
+	path, err := GetDebugLogPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(path); err == nil && info.Size()+int64(len(line)) > maxDebugLogSize {
+		rotateDebugLog(path)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(line)
+	return err
+}
+
+// rotateDebugLog shifts debug.jsonl to debug.jsonl.1, .1 to .2 and so on,
+// dropping the oldest file
+func rotateDebugLog(path string) {
+	os.Remove(fmt.Sprintf("%s.%d", path, maxDebugLogFiles))
+	for i := maxDebugLogFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	os.Rename(path, path+".1")
+}
+
+// withRequestID attaches a request ID to a context for the debug log
+func withRequestID(ctx context.Context, id int) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
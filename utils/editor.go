@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"codeaid/messages"
+	"os"
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// defaultEditor is used when neither $VISUAL nor $EDITOR is set
+const defaultEditor = "vi"
+
+// EditorCommand returns the editor from $VISUAL or $EDITOR, split into the
+// program and its arguments, such as "code --wait"
+func EditorCommand() []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(name)); len(fields) > 0 {
+			return fields
+		}
+	}
+	return []string{defaultEditor}
+}
+
+// OpenEditor suspends the TUI and opens the external editor on a temporary
+// file holding text, optionally after the last reply quoted with "> ". The
+// saved file comes back as an EditorMsg when the editor exits.
+func OpenEditor(text string, quoteReply, command bool) tea.Cmd {
+	if quoteReply {
+		if reply, ok := LastReply(); ok {
+			text = quote(reply) + "\n\n" + text
+		}
+	}
+
+	file, err := os.CreateTemp("", "codeaid-*.md")
+	if err != nil {
+		return editorError(err, command)
+	}
+	path := file.Name()
+	_, err = file.WriteString(text)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return editorError(err, command)
+	}
+
+	editor := EditorCommand()
+	cmd := exec.Command(editor[0], append(editor[1:], path)...)
+	// The editor needs the terminal itself, not the program's output writer
+	cmd.Stdout = os.Stdout
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		defer os.Remove(path)
+		if err != nil {
+			return messages.EditorMsg{Err: err, Command: command}
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return messages.EditorMsg{Err: err, Command: command}
+		}
+		// Editors add a final newline that is not part of the prompt
+		return messages.EditorMsg{Content: strings.TrimRight(string(data), "\n"), Command: command}
+	})
+}
+
+// editorError reports a failure before the editor could start
+func editorError(err error, command bool) tea.Cmd {
+	return func() tea.Msg {
+		return messages.EditorMsg{Err: err, Command: command}
+	}
+}
+
+// quote prefixes every line of text with "> "
+func quote(text string) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FakeModel is the model name reported by the fake provider
+const FakeModel = "fake/echo"
+
+// FakeReply is a scripted reply from the fake provider
+type FakeReply struct {
+	Content      string
+	FinishReason string
+}
+
+var (
+	fakeProvider bool
+	fakeReplies  []FakeReply
+	fakeMux      sync.Mutex
+)
+
+// UseFakeProvider answers every request locally instead of calling the
+// API. Replies queued with QueueFakeReply are used first; otherwise the
+// last user message is echoed back.
+func UseFakeProvider() {
+	fakeMux.Lock()
+	defer fakeMux.Unlock()
+
+	fakeProvider = true
+}
+
+// usingFakeProvider reports whether requests are answered locally
+func usingFakeProvider() bool {
+	fakeMux.Lock()
+	defer fakeMux.Unlock()
+
+	return fakeProvider
+}
+
+// QueueFakeReply adds a reply for the fake provider to return next
+func QueueFakeReply(reply FakeReply) {
+	fakeMux.Lock()
+	defer fakeMux.Unlock()
+
+	fakeReplies = append(fakeReplies, reply)
+}
+
+// nextFakeReply returns the next queued reply, or an echo of prompt
+func nextFakeReply(prompt string) FakeReply {
+	fakeMux.Lock()
+	defer fakeMux.Unlock()
+
+	if len(fakeReplies) == 0 {
+		return FakeReply{Content: "Echo: " + prompt, FinishReason: "stop"}
+	}
+	reply := fakeReplies[0]
+	fakeReplies = fakeReplies[1:]
+	if reply.FinishReason == "" {
+		reply.FinishReason = "stop"
+	}
+	return reply
+}
+
+// fakeTransport is an http.RoundTripper that answers chat completion
+// requests without a network
+type fakeTransport struct{}
+
+// RoundTrip returns a chat completion built from the next fake reply,
+// as server-sent events when the request asks for a stream
+func (fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var request struct {
+		Stream   bool `json:"stream"`
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+	}
+
+	// Find the text of the last user message; multi-part content is
+	// reduced to its text parts
+	prompt := ""
+	promptWords := 0
+	for _, msg := range request.Messages {
+		var text string
+		if json.Unmarshal(msg.Content, &text) != nil {
+			var parts []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			}
+			json.Unmarshal(msg.Content, &parts)
+			for _, part := range parts {
+				text += part.Text
+			}
+		}
+		promptWords += len(strings.Fields(text))
+		if msg.Role == "user" {
+			prompt = text
+		}
+	}
+
+	reply := nextFakeReply(prompt)
+	body, err := json.Marshal(map[string]interface{}{
+		"id":     "fake",
+		"object": "chat.completion",
+		"model":  FakeModel,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"message":       map[string]string{"role": "assistant", "content": reply.Content},
+			"finish_reason": reply.FinishReason,
+		}},
+		// Word counts stand in for tokens so they stay predictable
+		"usage": map[string]int{
+			"prompt_tokens":     promptWords,
+			"completion_tokens": len(strings.Fields(reply.Content)),
+			"total_tokens":      promptWords + len(strings.Fields(reply.Content)),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	contentType := "application/json"
+	if request.Stream {
+		if body, err = completionStream(body); err != nil {
+			return nil, err
+		}
+		contentType = "text/event-stream"
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{contentType}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"codeaid/config"
+	"fmt"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Notification events
+const (
+	NotifyReply = "reply" // A reply or comparison arrived
+	NotifyError = "error" // A request failed
+)
+
+// Window titles, with a marker while a notification is unseen
+const (
+	windowTitle      = "codeaid"
+	replyWindowTitle = "● codeaid: reply ready"
+	errorWindowTitle = "✗ codeaid: request failed"
+)
+
+// Notify announces an event with the configured methods. The returned
+// command writes the bell, desktop notification and title marker through
+// the program output; it reports whether a title marker was set. Nothing
+// is sent when the output is not a terminal.
+func Notify(event, message string) (tea.Cmd, bool) {
+	if currentTerminal() == nil {
+		return nil, false
+	}
+	resolved, err := config.Resolve()
+	if err != nil {
+		resolved = &config.Resolved{}
+	}
+	methods := resolved.NotifyMethods(event)
+
+	// Keep control characters out of the escape sequences
+	message = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, message)
+
+	var sb strings.Builder
+	if slices.Contains(methods, "bell") {
+		sb.WriteString("\a")
+	}
+	if slices.Contains(methods, "osc9") {
+		fmt.Fprintf(&sb, "\x1b]9;%s: %s\a", windowTitle, message)
+	}
+	if slices.Contains(methods, "osc777") {
+		fmt.Fprintf(&sb, "\x1b]777;notify;%s;%s\a", windowTitle, message)
+	}
+	alert := writeTerminal(sb.String())
+
+	if !slices.Contains(methods, "title") {
+		return alert, false
+	}
+	title := replyWindowTitle
+	if event == NotifyError {
+		title = errorWindowTitle
+	}
+	return tea.Batch(alert, tea.SetWindowTitle(title)), true
+}
+
+// ClearTitleMarker restores the title saved at start once the user is
+// back, saving it again for the next marker
+func ClearTitleMarker() tea.Cmd {
+	return writeTerminal(restoreTitle + saveTitle)
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// RequestState is what the active request is doing
+type RequestState int
+
+const (
+	RequestIdle      RequestState = iota
+	RequestWaiting                // Sent, nothing received yet
+	RequestStreaming              // The reply is arriving
+	RequestRetrying               // Failed and about to be sent again
+	RequestTool                   // A plugin command is running
+)
+
+// Retries of requests that failed before any of the reply arrived
+const (
+	maxAttempts = 3
+	retryDelay  = time.Second // Doubled after each attempt
+)
+
+// RequestStatus describes the active request for the status line
+type RequestStatus struct {
+	State   RequestState
+	Attempt int    // Attempt about to be sent while retrying
+	Tool    string // Command running while State is RequestTool
+}
+
+// String describes the status in a few words
+func (s RequestStatus) String() string {
+	switch s.State {
+	case RequestWaiting:
+		return "waiting for reply"
+	case RequestStreaming:
+		return "streaming"
+	case RequestRetrying:
+		return fmt.Sprintf("retrying (%d/%d)", s.Attempt, maxAttempts)
+	case RequestTool:
+		return "running " + s.Tool
+	}
+	return "idle"
+}
+
+// runningTool is the plugin command being run, or ""
+var runningTool string
+
+// CurrentRequest returns what the active request or command is doing
+func CurrentRequest() RequestStatus {
+	requestMux.Lock()
+	defer requestMux.Unlock()
+
+	switch {
+	case activeRequest != nil:
+		return RequestStatus{State: activeRequest.state, Attempt: activeRequest.attempt}
+	case runningTool != "":
+		return RequestStatus{State: RequestTool, Tool: runningTool}
+	}
+	return RequestStatus{}
+}
+
+// StartTool marks a plugin command as running until the returned function
+// is called
+func StartTool(name string) func() {
+	requestMux.Lock()
+	defer requestMux.Unlock()
+
+	runningTool = name
+	return func() {
+		requestMux.Lock()
+		defer requestMux.Unlock()
+		if runningTool == name {
+			runningTool = ""
+		}
+	}
+}
+
+// setRequestState records the state of the request ctx belongs to, if it
+// is still the active one. Requests made for the API server carry no
+// request ID and are not tracked.
+func setRequestState(ctx context.Context, state RequestState, attempt int) {
+	id, ok := ctx.Value(requestIDKey{}).(int)
+	if !ok {
+		return
+	}
+
+	requestMux.Lock()
+	defer requestMux.Unlock()
+	if activeRequest != nil && activeRequest.id == id {
+		activeRequest.state = state
+		activeRequest.attempt = attempt
+	}
+}
+
+// retryable reports whether a failed request may succeed if sent again:
+// the provider was rate limiting or had a server error
+func retryable(err error) bool {
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	status := 0
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		status = reqErr.HTTPStatusCode
+	}
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// withRetries calls send until it succeeds, fails with an error that is
+// not retryable, or runs out of attempts, waiting longer after each
+// failure. The request state shows the attempt while waiting.
+func withRetries[T any](ctx context.Context, send func() (T, error)) (T, error) {
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		result, err := send()
+		if err == nil || attempt == maxAttempts || !retryable(err) {
+			return result, err
+		}
+
+		setRequestState(ctx, RequestRetrying, attempt+1)
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(delay):
+		}
+		setRequestState(ctx, RequestWaiting, 0)
+		delay *= 2
+	}
+}
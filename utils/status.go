@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"codeaid/config"
+	"codeaid/conversation"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusRefresh is how long settings, the directory and the git branch
+// are cached for the status line, which is drawn on every frame
+const statusRefresh = 2 * time.Second
+
+// Status holds what the status line shows
+type Status struct {
+	Items         []string // Items to show, in order
+	Model         string
+	Session       string
+	ContextTokens int // Tokens in the context of the last reply
+	ContextLimit  int // Context window of the model, 0 if unknown
+	Cost          float64
+	CostUnknown   bool // Some usage could not be priced
+	Dir           string
+	Branch        string
+}
+
+var (
+	sessionCost    float64
+	costUnknown    bool
+	statusCache    Status
+	statusCachedAt time.Time
+	statusMux      sync.Mutex
+)
+
+// recordUsage adds the cost of a reply to the session cost
+func recordUsage(meta conversation.Metadata) {
+	price, known := config.Price{}, false
+	if usingFakeProvider() {
+		known = true
+	} else if resolved, err := config.Resolve(); err == nil {
+		price, known = resolved.Price(meta.Model)
+	}
+
+	statusMux.Lock()
+	defer statusMux.Unlock()
+
+	if !known {
+		if meta.PromptTokens > 0 || meta.CompletionTokens > 0 {
+			costUnknown = true
+		}
+		return
+	}
+	sessionCost += price.Cost(meta.PromptTokens, meta.CompletionTokens)
+}
+
+// CurrentStatus returns the values shown in the status line
+func CurrentStatus() Status {
+	statusMux.Lock()
+	if time.Since(statusCachedAt) > statusRefresh {
+		statusCache = readStatus()
+		statusCachedAt = time.Now()
+	}
+	status := statusCache
+	status.Cost = sessionCost
+	status.CostUnknown = costUnknown
+	statusMux.Unlock()
+
+	// The context grows with each reply, so it is never cached
+	path := conversationTree.Path()
+	for i := len(path) - 1; i >= 0; i-- {
+		if meta := path[i].Meta; path[i].Kind == conversation.KindChat && meta.PromptTokens+meta.CompletionTokens > 0 {
+			status.ContextTokens = meta.PromptTokens + meta.CompletionTokens
+			break
+		}
+	}
+	return status
+}
+
+// readStatus reads the settings, directory and git branch
+func readStatus() Status {
+	status := Status{Items: config.StatusBarItems}
+
+	if resolved, err := config.Resolve(); err == nil {
+		if len(resolved.Data.StatusBar) > 0 {
+			status.Items = resolved.Data.StatusBar
+		}
+		status.Session = resolved.Data.SessionName
+		if settings, err := resolved.Settings(); err == nil {
+			status.Model = settings.Model
+			status.ContextLimit = resolved.ContextLimit(settings.Model)
+		}
+	}
+	if usingFakeProvider() {
+		status.Model = FakeModel
+	}
+
+	if dir, err := os.Getwd(); err == nil {
+		status.Dir = dir
+		status.Branch = gitBranch(dir)
+	}
+	return status
+}
+
+// gitBranch returns the branch checked out in the repository containing
+// dir, a short commit hash when detached, or "" outside a repository
+func gitBranch(dir string) string {
+	for {
+		gitPath := filepath.Join(dir, ".git")
+		if info, err := os.Stat(gitPath); err == nil {
+			// Worktrees and submodules have a .git file pointing elsewhere
+			if !info.IsDir() {
+				data, err := os.ReadFile(gitPath)
+				if err != nil {
+					return ""
+				}
+				target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+				if !ok {
+					return ""
+				}
+				if !filepath.IsAbs(target) {
+					target = filepath.Join(dir, target)
+				}
+				gitPath = target
+			}
+			head, err := os.ReadFile(filepath.Join(gitPath, "HEAD"))
+			if err != nil {
+				return ""
+			}
+			ref := strings.TrimSpace(string(head))
+			if branch, ok := strings.CutPrefix(ref, "ref: refs/heads/"); ok {
+				return branch
+			}
+			return ref[:min(len(ref), 7)]
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,261 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// streamDone ends a stream of server-sent events from the API
+const streamDone = "data: [DONE]"
+
+var (
+	streamHandler func(turnID int, delta string)
+	streamMux     sync.Mutex
+)
+
+// SetStreamHandler sets a function that receives each piece of a reply
+// as it arrives, with the ID of the turn it belongs to; nil removes it
+func SetStreamHandler(handler func(turnID int, delta string)) {
+	streamMux.Lock()
+	defer streamMux.Unlock()
+
+	streamHandler = handler
+}
+
+// notifyStream passes a piece of a reply to the stream handler, if any
+func notifyStream(turnID int, delta string) {
+	streamMux.Lock()
+	handler := streamHandler
+	streamMux.Unlock()
+
+	if handler != nil {
+		handler(turnID, delta)
+	}
+}
+
+// idleKey is the context key for a request's idle timer
+type idleKey struct{}
+
+// idleTimer cancels a request that has waited too long for the API
+type idleTimer struct {
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+// withIdleTimeout returns a context that is cancelled, with
+// context.DeadlineExceeded as its cause, when a request waits longer than
+// timeout for its reply to start or for the next piece of it. A long
+// reply that keeps arriving is never cut off.
+func withIdleTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	idle := &idleTimer{timeout: timeout}
+	idle.timer = time.AfterFunc(timeout, func() { cancel(context.DeadlineExceeded) })
+	return context.WithValue(ctx, idleKey{}, idle), func() {
+		idle.timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// keepAlive restarts the idle timeout of a request that heard from the API
+func keepAlive(ctx context.Context) {
+	if idle, ok := ctx.Value(idleKey{}).(*idleTimer); ok {
+		idle.timer.Reset(idle.timeout)
+	}
+}
+
+// timedOut reports whether a request ended because it timed out
+func timedOut(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), context.DeadlineExceeded)
+}
+
+// receiveStream reads a streamed reply to the end, passing each piece of
+// text to onDelta, and assembles the response the request would have
+// returned without streaming
+func receiveStream(ctx context.Context, stream *openai.ChatCompletionStream, onDelta func(string)) (openai.ChatCompletionResponse, error) {
+	var resp openai.ChatCompletionResponse
+	var content strings.Builder
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return resp, err
+		}
+		keepAlive(ctx)
+
+		if chunk.Model != "" {
+			resp.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			resp.Usage = *chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 {
+				continue
+			}
+			if len(resp.Choices) == 0 {
+				resp.Choices = []openai.ChatCompletionChoice{{
+					Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant},
+				}}
+			}
+			if delta := choice.Delta.Content; delta != "" {
+				if content.Len() == 0 {
+					setRequestState(ctx, RequestStreaming, 0)
+				}
+				content.WriteString(delta)
+				if onDelta != nil {
+					onDelta(delta)
+				}
+			}
+			if choice.FinishReason != "" {
+				resp.Choices[0].FinishReason = choice.FinishReason
+			}
+		}
+	}
+
+	if len(resp.Choices) > 0 {
+		resp.Choices[0].Message.Content = content.String()
+	}
+	return resp, nil
+}
+
+// requestsStream reports whether a request body asks for a streamed reply
+func requestsStream(body []byte) bool {
+	var request struct {
+		Stream bool `json:"stream"`
+	}
+	return json.Unmarshal(body, &request) == nil && request.Stream
+}
+
+// isEventStream reports whether a response is a stream of server-sent
+// events
+func isEventStream(header http.Header) bool {
+	return strings.HasPrefix(header.Get("Content-Type"), "text/event-stream")
+}
+
+// streamComplete reports whether a copy of an event stream reached its
+// end marker, rather than being closed early
+func streamComplete(body []byte) bool {
+	return bytes.Contains(body, []byte(streamDone))
+}
+
+// streamBody passes a response body through as it is read while keeping
+// a copy, and calls done with the copy once the body is read to the end
+// or closed. Streamed replies are logged and recorded this way without
+// holding any of them back.
+type streamBody struct {
+	body io.ReadCloser
+	copy bytes.Buffer
+	once sync.Once
+	done func(body []byte)
+}
+
+// newStreamBody wraps body, calling done with what was read at the end
+func newStreamBody(body io.ReadCloser, done func(body []byte)) *streamBody {
+	return &streamBody{body: body, done: done}
+}
+
+// Read reads from the body and keeps a copy
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.copy.Write(p[:n])
+	if err != nil {
+		b.finish()
+	}
+	return n, err
+}
+
+// Close closes the body
+func (b *streamBody) Close() error {
+	err := b.body.Close()
+	b.finish()
+	return err
+}
+
+// finish calls done once
+func (b *streamBody) finish() {
+	b.once.Do(func() {
+		b.done(b.copy.Bytes())
+	})
+}
+
+// completionStream turns a chat completion into the server-sent events a
+// streaming request receives: the reply a word at a time, then the finish
+// reason, the usage and the end marker
+func completionStream(body []byte) ([]byte, error) {
+	var resp openai.ChatCompletionResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid chat completion: %v", err)
+	}
+
+	var sb bytes.Buffer
+	write := func(choices []openai.ChatCompletionStreamChoice, usage *openai.Usage) {
+		data, _ := json.Marshal(openai.ChatCompletionStreamResponse{
+			ID:      resp.ID,
+			Object:  "chat.completion.chunk",
+			Created: resp.Created,
+			Model:   resp.Model,
+			Choices: choices,
+			Usage:   usage,
+		})
+		fmt.Fprintf(&sb, "data: %s\n\n", data)
+	}
+
+	if len(resp.Choices) > 0 {
+		choice := resp.Choices[0]
+		for i, word := range strings.SplitAfter(choice.Message.Content, " ") {
+			delta := openai.ChatCompletionStreamChoiceDelta{Content: word}
+			if i == 0 {
+				delta.Role = openai.ChatMessageRoleAssistant
+			}
+			write([]openai.ChatCompletionStreamChoice{{Delta: delta}}, nil)
+		}
+		write([]openai.ChatCompletionStreamChoice{{FinishReason: choice.FinishReason}}, nil)
+	}
+	write([]openai.ChatCompletionStreamChoice{}, &resp.Usage)
+	sb.WriteString(streamDone + "\n\n")
+	return sb.Bytes(), nil
+}
+
+// countStreamToolCalls counts the tool calls in a streamed chat
+// completion; each call's first chunk carries its ID
+func countStreamToolCalls(body []byte) int {
+	count := 0
+	for _, line := range strings.Split(string(body), "\n") {
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+		if !ok {
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					ToolCalls []struct {
+						ID string `json:"id"`
+					} `json:"tool_calls"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if json.Unmarshal([]byte(data), &chunk) != nil {
+			continue
+		}
+		for _, choice := range chunk.Choices {
+			for _, call := range choice.Delta.ToolCalls {
+				if call.ID != "" {
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
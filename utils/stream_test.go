@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"codeaid/config"
+	"codeaid/conversation"
+	"codeaid/messages"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// streamChunk is a server-sent event carrying a piece of a reply
+func streamChunk(content, finishReason string) string {
+	finish := "null"
+	if finishReason != "" {
+		finish = fmt.Sprintf("%q", finishReason)
+	}
+	return fmt.Sprintf("data: {\"model\":\"test\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q},\"finish_reason\":%s}]}\n\n", content, finish)
+}
+
+// useTestAPI sends requests to a test server through a profile, with
+// extra top-level settings, and returns the configuration directory
+func useTestAPI(t *testing.T, url, extra string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	cfg := fmt.Sprintf(`{"version": 1, %s, "profile": "test",
+		"profiles": {"test": {"provider": "local", "base_url": %q, "model": "test"}}}`, extra, url+"/v1")
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(cfg), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.Isolate(dir)
+	t.Cleanup(func() {
+		config.Isolate("")
+		clientInitMux.Lock()
+		clientInitialized = false
+		clientInitMux.Unlock()
+		conversationTree.Clear()
+	})
+	return dir
+}
+
+func TestDebugLogPassesStreamThrough(t *testing.T) {
+	// The server holds the rest of the reply back until the first piece
+	// has reached the caller, which only happens if nothing buffers it
+	first := make(chan struct{})
+	var held atomic.Bool
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, streamChunk("Hello", ""))
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-first:
+		case <-time.After(5 * time.Second):
+			held.Store(true)
+		}
+		fmt.Fprint(w, streamChunk(" world", "stop"))
+		fmt.Fprint(w, streamDone+"\n\n")
+	}))
+	defer api.Close()
+
+	dir := useTestAPI(t, api.URL, `"debug": true`)
+
+	var pieces []string
+	reply, meta, err := requestReply(context.Background(), []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: "Hi"},
+	}, func(delta string) {
+		if len(pieces) == 0 {
+			close(first)
+		}
+		pieces = append(pieces, delta)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held.Load() {
+		t.Error("the first piece only arrived with the rest of the reply")
+	}
+	if reply != "Hello world" || len(pieces) != 2 || meta.FinishReason != "stop" {
+		t.Errorf("got reply %q in pieces %q, finish %q", reply, pieces, meta.FinishReason)
+	}
+
+	exchange, ok := LastExchange()
+	if !ok || exchange.Response == nil || !strings.Contains(exchange.Response.Text, " world") {
+		t.Fatalf("the debug log did not record the whole stream: %+v", exchange)
+	}
+	log, err := os.ReadFile(filepath.Join(dir, "logs", debugLogName))
+	if err != nil || !strings.Contains(string(log), `\"content\":\" world\"`) {
+		t.Errorf("the debug log file does not hold the stream (%v)", err)
+	}
+}
+
+// The timeout limits the wait for each piece of a reply, not the whole
+// reply, so a long answer that keeps arriving is not cut off
+func TestTimeoutAllowsLongStreams(t *testing.T) {
+	tests := []struct {
+		name  string
+		gaps  []time.Duration
+		reply string
+	}{
+		{"steady", []time.Duration{0, 400 * time.Millisecond, 400 * time.Millisecond, 400 * time.Millisecond}, "one two three four"},
+		{"stalled", []time.Duration{0, 1500 * time.Millisecond}, ""},
+	}
+	words := []string{"one", " two", " three", " four"}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				for i, gap := range test.gaps {
+					select {
+					case <-time.After(gap):
+					case <-r.Context().Done():
+						return
+					}
+					fmt.Fprint(w, streamChunk(words[i], ""))
+					w.(http.Flusher).Flush()
+				}
+				fmt.Fprint(w, streamChunk("", "stop"))
+				fmt.Fprint(w, streamDone+"\n\n")
+			}))
+			defer api.Close()
+			useTestAPI(t, api.URL, `"http": {"timeout": 1}`)
+
+			start := time.Now()
+			msg := FetchReply("Count to four")()
+			resp, ok := msg.(messages.ResponseMsg)
+			if !ok {
+				t.Fatalf("got %#v, want a response", msg)
+			}
+			EndRequest(resp.RequestID)
+
+			if test.reply != "" {
+				if resp.Content != test.reply || time.Since(start) < time.Second {
+					t.Errorf("got %q after %s, want %q streamed over more than the timeout", resp.Content, time.Since(start), test.reply)
+				}
+				return
+			}
+			turn, _ := conversationTree.LastPrompted()
+			if !strings.Contains(resp.Content, "timed out") || turn.State != conversation.StateFailed {
+				t.Errorf("got %q with the turn %s, want a timeout", resp.Content, turn.State)
+			}
+		})
+	}
+}
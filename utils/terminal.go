@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"os"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/term"
+)
+
+// Window title escape sequences. The title is saved on the terminal's
+// title stack at start, so notification markers can be cleared by
+// restoring it rather than overwriting the user's own title.
+const (
+	saveTitle    = "\x1b[22;0t"
+	restoreTitle = "\x1b[23;0t"
+)
+
+// Terminal is the program's output when it is a terminal. Frames and
+// escape sequences written by commands take the same lock, so a
+// notification never lands in the middle of a frame.
+type Terminal struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// terminal receives escape sequences from commands; it is nil when the
+// output is not a terminal, as in script and stdio mode
+var (
+	terminalMu sync.Mutex
+	terminal   *Terminal
+)
+
+// NewTerminal wraps file for use as the program output, or returns false
+// when file is not a terminal
+func NewTerminal(file *os.File) (*Terminal, bool) {
+	if !term.IsTerminal(int(file.Fd())) {
+		return nil, false
+	}
+	return &Terminal{file: file}, true
+}
+
+// Write writes p to the terminal in one piece
+func (t *Terminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.file.Write(p)
+}
+
+// Read reads from the terminal; it is needed for Bubble Tea to treat the
+// output as a terminal
+func (t *Terminal) Read(p []byte) (int, error) {
+	return t.file.Read(p)
+}
+
+// Fd returns the terminal's file descriptor
+func (t *Terminal) Fd() uintptr {
+	return t.file.Fd()
+}
+
+// Close leaves the terminal open; it belongs to the process
+func (t *Terminal) Close() error {
+	return nil
+}
+
+// File returns the underlying terminal file, for programs that need the
+// terminal itself such as the external editor
+func (t *Terminal) File() *os.File {
+	return t.file
+}
+
+// UseTerminal sends escape sequences from commands to t and saves the
+// window title until CloseTerminal
+func UseTerminal(t *Terminal) {
+	terminalMu.Lock()
+	defer terminalMu.Unlock()
+	terminal = t
+	t.Write([]byte(saveTitle))
+}
+
+// CloseTerminal restores the window title saved by UseTerminal
+func CloseTerminal() {
+	terminalMu.Lock()
+	defer terminalMu.Unlock()
+	if terminal != nil {
+		terminal.Write([]byte(restoreTitle))
+		terminal = nil
+	}
+}
+
+// currentTerminal returns the terminal set by UseTerminal, or nil
+func currentTerminal() *Terminal {
+	terminalMu.Lock()
+	defer terminalMu.Unlock()
+	return terminal
+}
+
+// writeTerminal returns a command writing seq to the terminal, or nil
+// when there is no terminal to write to
+func writeTerminal(seq string) tea.Cmd {
+	t := currentTerminal()
+	if t == nil || seq == "" {
+		return nil
+	}
+	return func() tea.Msg {
+		t.Write([]byte(seq))
+		return nil
+	}
+}
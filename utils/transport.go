@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"codeaid/config"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// openRouterTitle and openRouterReferer identify the app to OpenRouter
+// unless X-Title or HTTP-Referer headers are configured
+const (
+	openRouterTitle   = "codeaid"
+	openRouterReferer = "https://github.com/cszackrison/codeaid"
+)
+
+// headerTransport adds fixed headers to every request
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+// RoundTrip adds the headers to a copy of the request and sends it
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newHTTPClient builds the HTTP client for the API from the http settings.
+// Requests pass through the header transport, then the debug transport so
+// the logged headers are the ones sent, then the cassette transport when
+// recording or replaying, then the network transport (or the fake provider).
+func newHTTPClient(settings config.Settings) (*http.Client, error) {
+	transport, err := newTransport(settings.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{}
+	if settings.Provider == config.ProviderOpenRouter {
+		headers["X-Title"] = openRouterTitle
+		headers[http.CanonicalHeaderKey("HTTP-Referer")] = openRouterReferer
+	}
+	for name, value := range settings.HTTP.Headers {
+		headers[http.CanonicalHeaderKey(name)] = value
+	}
+
+	base := newCassetteTransport(transport)
+	if usingFakeProvider() {
+		base = fakeTransport{}
+	}
+
+	return &http.Client{
+		Transport: &headerTransport{
+			base:    newDebugTransport(base, settings),
+			headers: headers,
+		},
+	}, nil
+}
+
+// newTransport configures the proxy, certificates and connect timeout
+func newTransport(cfg config.HTTPConfig) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.Proxy != "" {
+		proxy, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("http.proxy: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if cfg.ConnectTimeout > 0 {
+		dialer := &net.Dialer{
+			Timeout:   time.Duration(cfg.ConnectTimeout) * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = dialer.Timeout
+	}
+
+	if cfg.CABundle == "" && cfg.ClientCert == "" {
+		return transport, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CABundle != "" {
+		pem, err := os.ReadFile(cfg.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("http.ca_bundle: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("http.ca_bundle: no certificates found in %s", cfg.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("http.client_cert: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}